@@ -0,0 +1,206 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
+	"github.com/hnchenkai/mx-wsgo/limitcount"
+	"github.com/hnchenkai/mx-wsgo/serverunit"
+)
+
+// 携带token的请求头，也可以使用 Authorization: Bearer <token>
+const TokenHeader = "X-Admin-Token"
+
+// 管理接口，挂载的时候使用 http.StripPrefix 去掉前缀
+//
+//	GET  /connections                     链接列表，支持 ?group= 和 ?status= 过滤
+//	GET  /connections/{id}                单个链接
+//	POST /connections/{id}/kick?reason=   踢掉链接
+//	POST /connections/{id}/promote        排队中的链接直接接入
+//	GET  /queues                          每个分组的等待队列和位置
+//	POST /broadcast                       广播消息 {"group":"","code":200,"message":"","header":{}}
+type Handler struct {
+	unit  *serverunit.ServerUnit
+	token string
+}
+
+// New 生成管理接口，token 为空的时候拒绝所有请求
+func New(unit *serverunit.ServerUnit, token string) *Handler {
+	return &Handler{unit: unit, token: token}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "invalid admin token")
+		return
+	}
+
+	path := strings.Trim(r.URL.Path, "/")
+	switch {
+	case path == "connections":
+		h.onlyMethod(w, r, http.MethodGet, h.listConnections)
+	case path == "queues":
+		h.onlyMethod(w, r, http.MethodGet, h.listQueues)
+	case path == "broadcast":
+		h.onlyMethod(w, r, http.MethodPost, h.broadcast)
+	case strings.HasPrefix(path, "connections/"):
+		parts := strings.Split(strings.TrimPrefix(path, "connections/"), "/")
+		id := parts[0]
+		switch {
+		case len(parts) == 1:
+			h.onlyMethod(w, r, http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+				h.getConnection(w, id)
+			})
+		case len(parts) == 2 && parts[1] == "kick":
+			h.onlyMethod(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+				h.kick(w, r, id)
+			})
+		case len(parts) == 2 && parts[1] == "promote":
+			h.onlyMethod(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+				h.promote(w, id)
+			})
+		default:
+			writeError(w, http.StatusNotFound, "not found")
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *Handler) authorized(r *http.Request) bool {
+	if h.token == "" {
+		return false
+	}
+	token := r.Header.Get(TokenHeader)
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+func (h *Handler) onlyMethod(w http.ResponseWriter, r *http.Request, method string, fn http.HandlerFunc) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	fn(w, r)
+}
+
+func (h *Handler) listConnections(w http.ResponseWriter, r *http.Request) {
+	group := r.URL.Query().Get("group")
+	status := r.URL.Query().Get("status")
+	list := make([]serverunit.ConnInfo, 0)
+	for _, info := range h.unit.Connections() {
+		if group != "" && info.Group != group {
+			continue
+		}
+		if status != "" && info.Status != status {
+			continue
+		}
+		list = append(list, info)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"total":       len(list),
+		"connections": list,
+	})
+}
+
+func (h *Handler) getConnection(w http.ResponseWriter, id string) {
+	info, ok := h.unit.Connection(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, serverunit.ErrClientNotFound.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// 排队中的链接，位置和推送给客户端的一样
+type waitEntry struct {
+	ClientId string `json:"clientId"`
+	Position int64  `json:"position"`
+}
+
+func (h *Handler) listQueues(w http.ResponseWriter, r *http.Request) {
+	queues := make(map[string][]waitEntry)
+	for group, positions := range h.unit.WaitPositions() {
+		entries := make([]waitEntry, 0, len(positions))
+		for _, v := range positions {
+			entries = append(entries, waitEntry{ClientId: v.ClientId, Position: v.Position})
+		}
+		queues[group] = entries
+	}
+	writeJSON(w, http.StatusOK, queues)
+}
+
+func (h *Handler) kick(w http.ResponseWriter, r *http.Request, id string) {
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = "kicked by admin"
+	}
+	if !h.unit.Kick(id, reason) {
+		writeError(w, http.StatusNotFound, serverunit.ErrClientNotFound.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id": id, "reason": reason})
+}
+
+func (h *Handler) promote(w http.ResponseWriter, id string) {
+	err := h.unit.Promote(id)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, map[string]string{"id": id})
+	case errors.Is(err, serverunit.ErrClientNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, limitcount.ErrNotWaiting):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// 广播的消息，以 Messagev0 发送给客户端
+type broadcastRequest struct {
+	Group   string            `json:"group"`
+	Code    int32             `json:"code"`
+	Message string            `json:"message"`
+	Header  map[string]string `json:"header"`
+}
+
+func (h *Handler) broadcast(w http.ResponseWriter, r *http.Request) {
+	req := broadcastRequest{Code: http.StatusOK}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	coder := bytecoder.MarshalV0(0, req.Code, []byte(req.Message), req.Header)
+	coder.Gzip()
+	coder.EncodeWS()
+
+	sent := 0
+	if req.Group == "" {
+		sent = h.unit.Count()
+		h.unit.Broadcast(coder)
+	} else {
+		for _, info := range h.unit.Connections() {
+			if info.Group == req.Group && h.unit.Send(info.Id, coder) {
+				sent++
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"sent": sent})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"message": message})
+}
@@ -0,0 +1,137 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hnchenkai/mx-wsgo/admin"
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
+	"github.com/hnchenkai/mx-wsgo/limitcount"
+	"github.com/hnchenkai/mx-wsgo/serverunit"
+	"github.com/hnchenkai/mx-wsgo/wsclient"
+)
+
+func call(t *testing.T, h http.Handler, method, target, body string, out interface{}) int {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(admin.TokenHeader, "secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %v %s", method, target, err, rec.Body.String())
+		}
+	}
+	return rec.Code
+}
+
+func TestAdmin(t *testing.T) {
+	unit := serverunit.NewServerUnit(nil, &limitcount.LimitOption{
+		ReadyLimitFunc: func(limitkey string) int {
+			return 1
+		},
+		WaitLimitFunc: func(limitkey string) int {
+			return 5
+		},
+	})
+	go unit.Run()
+	defer unit.Close()
+	svr := httptest.NewServer(unit)
+	defer svr.Close()
+	url := "ws" + strings.TrimPrefix(svr.URL, "http")
+	h := admin.New(unit, "secret")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	kicked := make(chan string, 1)
+	first, err := wsclient.Dial(ctx, url, &wsclient.Options{
+		Group: "test",
+		OnClose: func(reason string) {
+			kicked <- reason
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if err := first.WaitAccept(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	waiting := make(chan struct{}, 1)
+	broadcast := make(chan string, 1)
+	second, err := wsclient.Dial(ctx, url, &wsclient.Options{
+		Group: "test",
+		OnWait: func(self, total int64) {
+			waiting <- struct{}{}
+		},
+		OnMessage: func(msg *bytecoder.Messagev0) {
+			broadcast <- string(msg.GetMessage())
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	<-waiting
+
+	// 没有token
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/connections", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+
+	list := struct {
+		Total       int                   `json:"total"`
+		Connections []serverunit.ConnInfo `json:"connections"`
+	}{}
+	if code := call(t, h, "GET", "/connections?group=test", "", &list); code != http.StatusOK || list.Total != 2 {
+		t.Fatalf("unexpected list %d %+v", code, list)
+	}
+	acceptId, waitId := list.Connections[0].Id, list.Connections[1].Id
+	if list.Connections[0].Status != "accept" || list.Connections[1].Status != "wait" {
+		t.Fatalf("unexpected status %+v", list.Connections)
+	}
+
+	queues := map[string][]struct {
+		ClientId string `json:"clientId"`
+		Position int    `json:"position"`
+	}{}
+	call(t, h, "GET", "/queues", "", &queues)
+	if len(queues["test"]) != 1 || queues["test"][0].ClientId != waitId || queues["test"][0].Position != 0 {
+		t.Fatalf("unexpected queues %+v", queues)
+	}
+
+	if code := call(t, h, "POST", "/connections/"+acceptId+"/promote", "", nil); code != http.StatusConflict {
+		t.Fatalf("unexpected promote status %d", code)
+	}
+	if code := call(t, h, "POST", "/connections/"+waitId+"/promote", "", nil); code != http.StatusOK {
+		t.Fatalf("unexpected promote status %d", code)
+	}
+	if err := second.WaitAccept(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := call(t, h, "POST", "/broadcast", `{"group":"test","message":"hello"}`, nil); code != http.StatusOK {
+		t.Fatalf("unexpected broadcast status %d", code)
+	}
+	if msg := <-broadcast; msg != "hello" {
+		t.Fatalf("unexpected broadcast %q", msg)
+	}
+
+	if code := call(t, h, "POST", "/connections/"+acceptId+"/kick?reason=maintenance", "", nil); code != http.StatusOK {
+		t.Fatalf("unexpected kick status %d", code)
+	}
+	if reason := <-kicked; reason != "maintenance" {
+		t.Fatalf("unexpected reason %q", reason)
+	}
+	if code := call(t, h, "GET", "/connections/missing", "", nil); code != http.StatusNotFound {
+		t.Fatalf("unexpected status %d", code)
+	}
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+)
+
+// mTLS 客户端证书鉴权器
+// 需要http服务配置 tls.Config.ClientAuth 要求客户端携带证书
+type CertAuthenticator struct {
+	// 不为空的时候使用这里的根证书校验证书链，
+	// 为空的时候要求tls握手阶段已经完成了校验(VerifiedChains不为空)
+	Roots *x509.CertPool
+}
+
+func NewCertAuthenticator(roots *x509.CertPool) *CertAuthenticator {
+	return &CertAuthenticator{
+		Roots: roots,
+	}
+}
+
+func (a *CertAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, Unauthorized("missing client certificate")
+	}
+	cert := r.TLS.PeerCertificates[0]
+
+	if a.Roots != nil {
+		intermediates := x509.NewCertPool()
+		for _, v := range r.TLS.PeerCertificates[1:] {
+			intermediates.AddCert(v)
+		}
+		if _, err := cert.Verify(x509.VerifyOptions{
+			Roots:         a.Roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}); err != nil {
+			return nil, Forbidden("invalid client certificate")
+		}
+	} else if len(r.TLS.VerifiedChains) == 0 {
+		return nil, Forbidden("unverified client certificate")
+	}
+
+	identity := &Identity{
+		Id: certIdentity(cert),
+		Attrs: map[string]string{
+			"Cert-Subject": cert.Subject.String(),
+			"Cert-Issuer":  cert.Issuer.String(),
+			"Cert-Serial":  cert.SerialNumber.String(),
+		},
+	}
+	sum := sha256.Sum256(cert.Raw)
+	identity.Attrs["Cert-Fingerprint"] = hex.EncodeToString(sum[:])
+	if identity.Id == "" {
+		return nil, Forbidden("missing certificate identity")
+	}
+
+	return identity, nil
+}
+
+// 优先使用CN，没有的话使用SAN
+func certIdentity(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	}
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	return ""
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var jwtHashes = map[string]func() hash.Hash{
+	"HS256": sha256.New,
+	"HS384": sha512.New384,
+	"HS512": sha512.New,
+}
+
+// HMAC-JWT 鉴权器，token 从 Authorization 头或者 query 参数中获取
+type JWTAuthenticator struct {
+	// 签名密钥，不能为空
+	Secret []byte
+	// 允许的签名算法 默认 HS256
+	Algorithms []string
+	// 读取token的header 默认 Authorization，支持 Bearer 前缀
+	HeaderName string
+	// 读取token的query参数 默认 token，浏览器无法设置header的时候使用
+	QueryParam string
+	// 作为身份标识的claim 默认 sub
+	IdentityClaim string
+	// 需要写入链接header的claim，token里面没有的写入空值，不能由客户端自己设置
+	Claims []string
+	// 校验 iss，为空不校验
+	Issuer string
+	// 校验 aud，为空不校验
+	Audience string
+	// 时间校验的容差
+	Leeway time.Duration
+}
+
+var ErrEmptySecret = errors.New("jwt secret is empty")
+
+// NewJWTAuthenticator 密钥为空的时候任何人都可以签发token，返回 ErrEmptySecret
+func NewJWTAuthenticator(secret []byte) (*JWTAuthenticator, error) {
+	if len(secret) == 0 {
+		return nil, ErrEmptySecret
+	}
+	return &JWTAuthenticator{
+		Secret: secret,
+	}, nil
+}
+
+func (a *JWTAuthenticator) token(r *http.Request) string {
+	headerName := a.HeaderName
+	if headerName == "" {
+		headerName = "Authorization"
+	}
+	if token := r.Header.Get(headerName); token != "" {
+		if len(token) > 7 && strings.EqualFold(token[:7], "Bearer ") {
+			token = token[7:]
+		}
+		return strings.TrimSpace(token)
+	}
+
+	queryParam := a.QueryParam
+	if queryParam == "" {
+		queryParam = "token"
+	}
+	return r.URL.Query().Get(queryParam)
+}
+
+func (a *JWTAuthenticator) allowAlg(alg string) bool {
+	if len(a.Algorithms) == 0 {
+		return alg == "HS256"
+	}
+	for _, v := range a.Algorithms {
+		if v == alg {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := a.token(r)
+	if token == "" {
+		return nil, Unauthorized("missing token")
+	}
+
+	claims, err := a.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+
+	identityClaim := a.IdentityClaim
+	if identityClaim == "" {
+		identityClaim = "sub"
+	}
+	identity := &Identity{
+		Id:    claimString(claims[identityClaim]),
+		Attrs: map[string]string{},
+	}
+	if identity.Id == "" {
+		return nil, Forbidden("missing identity claim")
+	}
+	for _, k := range a.Claims {
+		identity.Attrs[k] = claimString(claims[k])
+	}
+
+	return identity, nil
+}
+
+// Parse 校验token并返回claims
+func (a *JWTAuthenticator) Parse(token string) (map[string]interface{}, error) {
+	if len(a.Secret) == 0 {
+		return nil, Unauthorized(ErrEmptySecret.Error())
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, Unauthorized("malformed token")
+	}
+
+	var head struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &head); err != nil {
+		return nil, Unauthorized("malformed token header")
+	}
+	newHash, ok := jwtHashes[head.Alg]
+	if !ok || !a.allowAlg(head.Alg) {
+		return nil, Unauthorized("unsupported token algorithm")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, Unauthorized("malformed token signature")
+	}
+	mac := hmac.New(newHash, a.Secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, Unauthorized("invalid token signature")
+	}
+
+	claims := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, Unauthorized("malformed token claims")
+	}
+
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(a.Leeway)) {
+		return nil, Unauthorized("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(a.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, Unauthorized("token not valid yet")
+	}
+	if a.Issuer != "" && claimString(claims["iss"]) != a.Issuer {
+		return nil, Forbidden("invalid token issuer")
+	}
+	if a.Audience != "" && !claimContains(claims["aud"], a.Audience) {
+		return nil, Forbidden("invalid token audience")
+	}
+
+	return claims, nil
+}
+
+// SignJWT 生成一个HMAC签名的token，主要给客户端和测试使用
+func SignJWT(secret []byte, alg string, claims map[string]interface{}) (string, error) {
+	newHash, ok := jwtHashes[alg]
+	if !ok {
+		return "", fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	head, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signing := base64.RawURLEncoding.EncodeToString(head) + "." + base64.RawURLEncoding.EncodeToString(body)
+	mac := hmac.New(newHash, secret)
+	mac.Write([]byte(signing))
+	return signing + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	bt, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bt, v)
+}
+
+func claimString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		bt, _ := json.Marshal(val)
+		return string(bt)
+	}
+}
+
+func claimContains(v interface{}, target string) bool {
+	switch val := v.(type) {
+	case string:
+		return val == target
+	case []interface{}:
+		for _, item := range val {
+			if s, ok := item.(string); ok && s == target {
+				return true
+			}
+		}
+	}
+	return false
+}
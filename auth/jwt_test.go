@@ -0,0 +1,165 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hnchenkai/mx-wsgo/auth"
+	"github.com/hnchenkai/mx-wsgo/serverunit"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+var secret = []byte("test-secret")
+
+func sign(t *testing.T, claims map[string]interface{}) string {
+	token, err := auth.SignJWT(secret, "HS256", claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func newAuthenticator(t *testing.T) *auth.JWTAuthenticator {
+	a, err := auth.NewJWTAuthenticator(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	a := newAuthenticator(t)
+	a.Claims = []string{"role"}
+
+	token := sign(t, map[string]interface{}{
+		"sub":  "user-1",
+		"role": "vip",
+		"exp":  time.Now().Add(time.Minute).Unix(),
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	identity, err := a.Authenticate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Id != "user-1" || identity.Attrs["role"] != "vip" {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+
+	// query参数
+	r = httptest.NewRequest(http.MethodGet, "/ws?token="+token, nil)
+	if _, err := a.Authenticate(r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// token里面没有的claim，客户端不能通过 Mx-Ws- 前缀自己设置
+func TestJWTSpoofClaim(t *testing.T) {
+	roles := make(chan string, 1)
+	a := newAuthenticator(t)
+	a.Claims = []string{"role"}
+	unit := serverunit.NewServerUnit(func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
+		if cmd == wsmessage.CmdAccept {
+			roles <- msg.OrgHeader.Get("Role")
+		}
+	}, nil, serverunit.WithAuthenticator(a))
+	go unit.Run()
+	defer unit.Close()
+
+	svr := httptest.NewServer(unit)
+	defer svr.Close()
+	url := "ws" + strings.TrimPrefix(svr.URL, "http")
+
+	header := http.Header{}
+	header.Set(wsmessage.PrefixProxyHeader+"Role", "admin")
+	token := sign(t, map[string]interface{}{"sub": "user-3"})
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?token="+token, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case role := <-roles:
+		if role != "" {
+			t.Fatalf("spoofed role %q", role)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("accept timeout")
+	}
+}
+
+func TestJWTReject(t *testing.T) {
+	a := newAuthenticator(t)
+
+	cases := map[string]string{
+		"missing": "",
+		"expired": sign(t, map[string]interface{}{"sub": "user-1", "exp": time.Now().Add(-time.Minute).Unix()}),
+		"badsign": sign(t, map[string]interface{}{"sub": "user-1"}) + "x",
+	}
+	for name, token := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/ws?token="+token, nil)
+		if _, err := a.Authenticate(r); err == nil {
+			t.Fatalf("%s: expected error", name)
+		} else if auth.StatusCode(err) != http.StatusUnauthorized {
+			t.Fatalf("%s: unexpected code %d", name, auth.StatusCode(err))
+		}
+	}
+}
+
+// 密钥为空的时候任何人都可以签发token
+func TestJWTEmptySecret(t *testing.T) {
+	if _, err := auth.NewJWTAuthenticator(nil); err != auth.ErrEmptySecret {
+		t.Fatalf("expected ErrEmptySecret, got %v", err)
+	}
+
+	token, err := auth.SignJWT(nil, "HS256", map[string]interface{}{"sub": "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &auth.JWTAuthenticator{}
+	r := httptest.NewRequest(http.MethodGet, "/ws?token="+token, nil)
+	if _, err := a.Authenticate(r); err == nil || auth.StatusCode(err) != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %v", err)
+	}
+}
+
+func TestServeHTTPAuth(t *testing.T) {
+	identities := make(chan string, 1)
+	unit := serverunit.NewServerUnit(func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
+		if cmd == wsmessage.CmdAccept {
+			identities <- msg.Identity()
+		}
+	}, nil, serverunit.WithAuthenticator(newAuthenticator(t)))
+	go unit.Run()
+
+	svr := httptest.NewServer(unit)
+	defer svr.Close()
+	url := "ws" + strings.TrimPrefix(svr.URL, "http")
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %v", err)
+	}
+
+	token := sign(t, map[string]interface{}{"sub": "user-2"})
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?token="+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case id := <-identities:
+		if id != "user-2" {
+			t.Fatalf("unexpected identity %s", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("accept timeout")
+	}
+}
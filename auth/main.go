@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+)
+
+// 鉴权通过后的身份信息
+type Identity struct {
+	// 身份标识，会写入链接的 Mx-Wsgo-Identity 头
+	Id string
+	// 额外的属性，会写入链接的header中，值为空的会删除客户端转发的同名头
+	Attrs map[string]string
+}
+
+// 鉴权器，在http升级到ws之前执行
+type Authenticator interface {
+	// 返回错误表示拒绝链接，可以使用 *Error 指定http状态码
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+type AuthenticatorFunc func(r *http.Request) (*Identity, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*Identity, error) {
+	return f(r)
+}
+
+// 带http状态码的鉴权错误
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// 401 未认证
+func Unauthorized(msg string) error {
+	return &Error{Code: http.StatusUnauthorized, Message: msg}
+}
+
+// 403 无权限
+func Forbidden(msg string) error {
+	return &Error{Code: http.StatusForbidden, Message: msg}
+}
+
+// StatusCode 获取错误对应的http状态码，默认401
+func StatusCode(err error) int {
+	var authErr *Error
+	if errors.As(err, &authErr) && authErr.Code > 0 {
+		return authErr.Code
+	}
+	return http.StatusUnauthorized
+}
@@ -80,6 +80,8 @@ const (
 	MsgLocalCmd_MSG_LOCAL_CMD_WS_ACCEPT       MsgLocalCmd = 801 // 链接发起成功
 	MsgLocalCmd_MSG_LOCAL_CMD_WS_WAIT         MsgLocalCmd = 802 // 链接发起等待
 	MsgLocalCmd_MSG_LOCAL_CMD_WS_CLOSE        MsgLocalCmd = 803 // 链接发起断开
+	MsgLocalCmd_MSG_LOCAL_CMD_WS_SESSION      MsgLocalCmd = 804 // 下发会话信息，断线重连的时候带上token可以恢复会话
+	MsgLocalCmd_MSG_LOCAL_CMD_WS_RESYNC       MsgLocalCmd = 805 // 断开期间缺失的消息超过了重放缓冲区，需要重新同步
 	MsgLocalCmd_MSG_LOCAL_CMD_WS_REQ          MsgLocalCmd = 810 // 客户端主动询问自己前面还有几个人
 	MsgLocalCmd_MSG_LOCAL_CMD_WS_RESP         MsgLocalCmd = 811
 )
@@ -91,6 +93,8 @@ var (
 		801: "MSG_LOCAL_CMD_WS_ACCEPT",
 		802: "MSG_LOCAL_CMD_WS_WAIT",
 		803: "MSG_LOCAL_CMD_WS_CLOSE",
+		804: "MSG_LOCAL_CMD_WS_SESSION",
+		805: "MSG_LOCAL_CMD_WS_RESYNC",
 		810: "MSG_LOCAL_CMD_WS_REQ",
 		811: "MSG_LOCAL_CMD_WS_RESP",
 	}
@@ -99,6 +103,8 @@ var (
 		"MSG_LOCAL_CMD_WS_ACCEPT":       801,
 		"MSG_LOCAL_CMD_WS_WAIT":         802,
 		"MSG_LOCAL_CMD_WS_CLOSE":        803,
+		"MSG_LOCAL_CMD_WS_SESSION":      804,
+		"MSG_LOCAL_CMD_WS_RESYNC":       805,
 		"MSG_LOCAL_CMD_WS_REQ":          810,
 		"MSG_LOCAL_CMD_WS_RESP":         811,
 	}
@@ -525,8 +531,10 @@ type MessageWaitInfo struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Self  int64 `protobuf:"varint,1,opt,name=self,proto3" json:"self,omitempty"`
-	Total int64 `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
+	Self       int64   `protobuf:"varint,1,opt,name=self,proto3" json:"self,omitempty"`
+	Total      int64   `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
+	EtaSeconds int64   `protobuf:"varint,3,opt,name=eta_seconds,json=etaSeconds,proto3" json:"eta_seconds,omitempty"` // 预计多少秒之后接入，-1表示还没有足够的数据估算
+	Throughput float64 `protobuf:"fixed64,4,opt,name=throughput,proto3" json:"throughput,omitempty"`                  // 最近平均每秒接入的排队人数，使用redis的时候是整个集群的
 }
 
 func (x *MessageWaitInfo) Reset() {
@@ -575,6 +583,100 @@ func (x *MessageWaitInfo) GetTotal() int64 {
 	return 0
 }
 
+func (x *MessageWaitInfo) GetEtaSeconds() int64 {
+	if x != nil {
+		return x.EtaSeconds
+	}
+	return 0
+}
+
+func (x *MessageWaitInfo) GetThroughput() float64 {
+	if x != nil {
+		return x.Throughput
+	}
+	return 0
+}
+
+// 会话信息
+type MessageSessionInfo struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Token        string `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`                                    // 重连的时候带上的token
+	ClientId     string `protobuf:"bytes,2,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`              // 会话对应的链接id
+	GraceSeconds int64  `protobuf:"varint,3,opt,name=grace_seconds,json=graceSeconds,proto3" json:"grace_seconds,omitempty"` // 断开之后会话保留的时间
+	Resumed      bool   `protobuf:"varint,4,opt,name=resumed,proto3" json:"resumed,omitempty"`                               // 是否是恢复的会话
+	Seq          int64  `protobuf:"varint,5,opt,name=seq,proto3" json:"seq,omitempty"`                                       // 这条消息之后的第一条消息序号减一，除了会话和重新同步命令，每收到一条消息加一
+}
+
+func (x *MessageSessionInfo) Reset() {
+	*x = MessageSessionInfo{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_message_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MessageSessionInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MessageSessionInfo) ProtoMessage() {}
+
+func (x *MessageSessionInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_message_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MessageSessionInfo.ProtoReflect.Descriptor instead.
+func (*MessageSessionInfo) Descriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *MessageSessionInfo) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *MessageSessionInfo) GetClientId() string {
+	if x != nil {
+		return x.ClientId
+	}
+	return ""
+}
+
+func (x *MessageSessionInfo) GetGraceSeconds() int64 {
+	if x != nil {
+		return x.GraceSeconds
+	}
+	return 0
+}
+
+func (x *MessageSessionInfo) GetResumed() bool {
+	if x != nil {
+		return x.Resumed
+	}
+	return false
+}
+
+func (x *MessageSessionInfo) GetSeq() int64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
 var File_message_proto protoreflect.FileDescriptor
 
 var file_message_proto_rawDesc = []byte{
@@ -657,31 +759,49 @@ var file_message_proto_rawDesc = []byte{
 	0x39, 0x0a, 0x0b, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10,
 	0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79,
 	0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
-	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x3b, 0x0a, 0x0f, 0x4d, 0x65,
+	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x7c, 0x0a, 0x0f, 0x4d, 0x65,
 	0x73, 0x73, 0x61, 0x67, 0x65, 0x57, 0x61, 0x69, 0x74, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x12, 0x0a,
 	0x04, 0x73, 0x65, 0x6c, 0x66, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x73, 0x65, 0x6c,
 	0x66, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03,
-	0x52, 0x05, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x2a, 0x53, 0x0a, 0x07, 0x56, 0x65, 0x72, 0x73, 0x69,
-	0x6f, 0x6e, 0x12, 0x19, 0x0a, 0x15, 0x56, 0x45, 0x52, 0x53, 0x49, 0x4f, 0x4e, 0x5f, 0x30, 0x5f,
-	0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x0d, 0x0a,
-	0x09, 0x56, 0x45, 0x52, 0x53, 0x49, 0x4f, 0x4e, 0x5f, 0x31, 0x10, 0x01, 0x12, 0x0d, 0x0a, 0x09,
-	0x56, 0x45, 0x52, 0x53, 0x49, 0x4f, 0x4e, 0x5f, 0x32, 0x10, 0x02, 0x12, 0x0f, 0x0a, 0x0b, 0x56,
-	0x45, 0x52, 0x53, 0x49, 0x4f, 0x4e, 0x5f, 0x43, 0x4d, 0x44, 0x10, 0x03, 0x2a, 0xbe, 0x01, 0x0a,
-	0x0b, 0x4d, 0x73, 0x67, 0x4c, 0x6f, 0x63, 0x61, 0x6c, 0x43, 0x6d, 0x64, 0x12, 0x21, 0x0a, 0x1d,
-	0x4d, 0x53, 0x47, 0x5f, 0x4c, 0x4f, 0x43, 0x41, 0x4c, 0x5f, 0x43, 0x4d, 0x44, 0x5f, 0x4e, 0x4f,
-	0x54, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12,
-	0x1c, 0x0a, 0x17, 0x4d, 0x53, 0x47, 0x5f, 0x4c, 0x4f, 0x43, 0x41, 0x4c, 0x5f, 0x43, 0x4d, 0x44,
-	0x5f, 0x57, 0x53, 0x5f, 0x41, 0x43, 0x43, 0x45, 0x50, 0x54, 0x10, 0xa1, 0x06, 0x12, 0x1a, 0x0a,
-	0x15, 0x4d, 0x53, 0x47, 0x5f, 0x4c, 0x4f, 0x43, 0x41, 0x4c, 0x5f, 0x43, 0x4d, 0x44, 0x5f, 0x57,
-	0x53, 0x5f, 0x57, 0x41, 0x49, 0x54, 0x10, 0xa2, 0x06, 0x12, 0x1b, 0x0a, 0x16, 0x4d, 0x53, 0x47,
-	0x5f, 0x4c, 0x4f, 0x43, 0x41, 0x4c, 0x5f, 0x43, 0x4d, 0x44, 0x5f, 0x57, 0x53, 0x5f, 0x43, 0x4c,
-	0x4f, 0x53, 0x45, 0x10, 0xa3, 0x06, 0x12, 0x19, 0x0a, 0x14, 0x4d, 0x53, 0x47, 0x5f, 0x4c, 0x4f,
-	0x43, 0x41, 0x4c, 0x5f, 0x43, 0x4d, 0x44, 0x5f, 0x57, 0x53, 0x5f, 0x52, 0x45, 0x51, 0x10, 0xaa,
-	0x06, 0x12, 0x1a, 0x0a, 0x15, 0x4d, 0x53, 0x47, 0x5f, 0x4c, 0x4f, 0x43, 0x41, 0x4c, 0x5f, 0x43,
-	0x4d, 0x44, 0x5f, 0x57, 0x53, 0x5f, 0x52, 0x45, 0x53, 0x50, 0x10, 0xab, 0x06, 0x42, 0x27, 0x0a,
-	0x18, 0x63, 0x6e, 0x2e, 0x6d, 0x6f, 0x78, 0x69, 0x2e, 0x6d, 0x69, 0x64, 0x64, 0x6c, 0x65, 0x2e,
-	0x62, 0x79, 0x74, 0x65, 0x63, 0x6f, 0x64, 0x65, 0x72, 0x5a, 0x0b, 0x2e, 0x2f, 0x62, 0x79, 0x74,
-	0x65, 0x63, 0x6f, 0x64, 0x65, 0x72, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x52, 0x05, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x12, 0x1f, 0x0a, 0x0b, 0x65, 0x74, 0x61, 0x5f, 0x73,
+	0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0a, 0x65, 0x74,
+	0x61, 0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x12, 0x1e, 0x0a, 0x0a, 0x74, 0x68, 0x72, 0x6f,
+	0x75, 0x67, 0x68, 0x70, 0x75, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0a, 0x74, 0x68,
+	0x72, 0x6f, 0x75, 0x67, 0x68, 0x70, 0x75, 0x74, 0x22, 0x98, 0x01, 0x0a, 0x12, 0x4d, 0x65, 0x73,
+	0x73, 0x61, 0x67, 0x65, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x6e, 0x66, 0x6f, 0x12,
+	0x14, 0x0a, 0x05, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05,
+	0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x1b, 0x0a, 0x09, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x5f,
+	0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x63, 0x6c, 0x69, 0x65, 0x6e, 0x74,
+	0x49, 0x64, 0x12, 0x23, 0x0a, 0x0d, 0x67, 0x72, 0x61, 0x63, 0x65, 0x5f, 0x73, 0x65, 0x63, 0x6f,
+	0x6e, 0x64, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c, 0x67, 0x72, 0x61, 0x63, 0x65,
+	0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x72, 0x65, 0x73, 0x75, 0x6d,
+	0x65, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x72, 0x65, 0x73, 0x75, 0x6d, 0x65,
+	0x64, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x65, 0x71, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x03,
+	0x73, 0x65, 0x71, 0x2a, 0x53, 0x0a, 0x07, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x19,
+	0x0a, 0x15, 0x56, 0x45, 0x52, 0x53, 0x49, 0x4f, 0x4e, 0x5f, 0x30, 0x5f, 0x55, 0x4e, 0x53, 0x50,
+	0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x0d, 0x0a, 0x09, 0x56, 0x45, 0x52,
+	0x53, 0x49, 0x4f, 0x4e, 0x5f, 0x31, 0x10, 0x01, 0x12, 0x0d, 0x0a, 0x09, 0x56, 0x45, 0x52, 0x53,
+	0x49, 0x4f, 0x4e, 0x5f, 0x32, 0x10, 0x02, 0x12, 0x0f, 0x0a, 0x0b, 0x56, 0x45, 0x52, 0x53, 0x49,
+	0x4f, 0x4e, 0x5f, 0x43, 0x4d, 0x44, 0x10, 0x03, 0x2a, 0xfb, 0x01, 0x0a, 0x0b, 0x4d, 0x73, 0x67,
+	0x4c, 0x6f, 0x63, 0x61, 0x6c, 0x43, 0x6d, 0x64, 0x12, 0x21, 0x0a, 0x1d, 0x4d, 0x53, 0x47, 0x5f,
+	0x4c, 0x4f, 0x43, 0x41, 0x4c, 0x5f, 0x43, 0x4d, 0x44, 0x5f, 0x4e, 0x4f, 0x54, 0x5f, 0x55, 0x4e,
+	0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x1c, 0x0a, 0x17, 0x4d,
+	0x53, 0x47, 0x5f, 0x4c, 0x4f, 0x43, 0x41, 0x4c, 0x5f, 0x43, 0x4d, 0x44, 0x5f, 0x57, 0x53, 0x5f,
+	0x41, 0x43, 0x43, 0x45, 0x50, 0x54, 0x10, 0xa1, 0x06, 0x12, 0x1a, 0x0a, 0x15, 0x4d, 0x53, 0x47,
+	0x5f, 0x4c, 0x4f, 0x43, 0x41, 0x4c, 0x5f, 0x43, 0x4d, 0x44, 0x5f, 0x57, 0x53, 0x5f, 0x57, 0x41,
+	0x49, 0x54, 0x10, 0xa2, 0x06, 0x12, 0x1b, 0x0a, 0x16, 0x4d, 0x53, 0x47, 0x5f, 0x4c, 0x4f, 0x43,
+	0x41, 0x4c, 0x5f, 0x43, 0x4d, 0x44, 0x5f, 0x57, 0x53, 0x5f, 0x43, 0x4c, 0x4f, 0x53, 0x45, 0x10,
+	0xa3, 0x06, 0x12, 0x1d, 0x0a, 0x18, 0x4d, 0x53, 0x47, 0x5f, 0x4c, 0x4f, 0x43, 0x41, 0x4c, 0x5f,
+	0x43, 0x4d, 0x44, 0x5f, 0x57, 0x53, 0x5f, 0x53, 0x45, 0x53, 0x53, 0x49, 0x4f, 0x4e, 0x10, 0xa4,
+	0x06, 0x12, 0x1c, 0x0a, 0x17, 0x4d, 0x53, 0x47, 0x5f, 0x4c, 0x4f, 0x43, 0x41, 0x4c, 0x5f, 0x43,
+	0x4d, 0x44, 0x5f, 0x57, 0x53, 0x5f, 0x52, 0x45, 0x53, 0x59, 0x4e, 0x43, 0x10, 0xa5, 0x06, 0x12,
+	0x19, 0x0a, 0x14, 0x4d, 0x53, 0x47, 0x5f, 0x4c, 0x4f, 0x43, 0x41, 0x4c, 0x5f, 0x43, 0x4d, 0x44,
+	0x5f, 0x57, 0x53, 0x5f, 0x52, 0x45, 0x51, 0x10, 0xaa, 0x06, 0x12, 0x1a, 0x0a, 0x15, 0x4d, 0x53,
+	0x47, 0x5f, 0x4c, 0x4f, 0x43, 0x41, 0x4c, 0x5f, 0x43, 0x4d, 0x44, 0x5f, 0x57, 0x53, 0x5f, 0x52,
+	0x45, 0x53, 0x50, 0x10, 0xab, 0x06, 0x42, 0x27, 0x0a, 0x18, 0x63, 0x6e, 0x2e, 0x6d, 0x6f, 0x78,
+	0x69, 0x2e, 0x6d, 0x69, 0x64, 0x64, 0x6c, 0x65, 0x2e, 0x62, 0x79, 0x74, 0x65, 0x63, 0x6f, 0x64,
+	0x65, 0x72, 0x5a, 0x0b, 0x2e, 0x2f, 0x62, 0x79, 0x74, 0x65, 0x63, 0x6f, 0x64, 0x65, 0x72, 0x62,
+	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -697,30 +817,31 @@ func file_message_proto_rawDescGZIP() []byte {
 }
 
 var file_message_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
-var file_message_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_message_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
 var file_message_proto_goTypes = []interface{}{
-	(Version)(0),            // 0: cn.moxi.middle.bytecoder.Version
-	(MsgLocalCmd)(0),        // 1: cn.moxi.middle.bytecoder.MsgLocalCmd
-	(*Message)(nil),         // 2: cn.moxi.middle.bytecoder.Message
-	(*Messagev1)(nil),       // 3: cn.moxi.middle.bytecoder.Messagev1
-	(*Messagev2)(nil),       // 4: cn.moxi.middle.bytecoder.Messagev2
-	(*MessageCMD)(nil),      // 5: cn.moxi.middle.bytecoder.MessageCMD
-	(*Messagev0)(nil),       // 6: cn.moxi.middle.bytecoder.Messagev0
-	(*MessageWaitInfo)(nil), // 7: cn.moxi.middle.bytecoder.MessageWaitInfo
-	nil,                     // 8: cn.moxi.middle.bytecoder.Messagev1.HeaderEntry
-	nil,                     // 9: cn.moxi.middle.bytecoder.Messagev2.HeaderEntry
-	nil,                     // 10: cn.moxi.middle.bytecoder.Messagev0.HeaderEntry
+	(Version)(0),               // 0: cn.moxi.middle.bytecoder.Version
+	(MsgLocalCmd)(0),           // 1: cn.moxi.middle.bytecoder.MsgLocalCmd
+	(*Message)(nil),            // 2: cn.moxi.middle.bytecoder.Message
+	(*Messagev1)(nil),          // 3: cn.moxi.middle.bytecoder.Messagev1
+	(*Messagev2)(nil),          // 4: cn.moxi.middle.bytecoder.Messagev2
+	(*MessageCMD)(nil),         // 5: cn.moxi.middle.bytecoder.MessageCMD
+	(*Messagev0)(nil),          // 6: cn.moxi.middle.bytecoder.Messagev0
+	(*MessageWaitInfo)(nil),    // 7: cn.moxi.middle.bytecoder.MessageWaitInfo
+	(*MessageSessionInfo)(nil), // 8: cn.moxi.middle.bytecoder.MessageSessionInfo
+	nil,                        // 9: cn.moxi.middle.bytecoder.Messagev1.HeaderEntry
+	nil,                        // 10: cn.moxi.middle.bytecoder.Messagev2.HeaderEntry
+	nil,                        // 11: cn.moxi.middle.bytecoder.Messagev0.HeaderEntry
 }
 var file_message_proto_depIdxs = []int32{
 	0,  // 0: cn.moxi.middle.bytecoder.Message.version:type_name -> cn.moxi.middle.bytecoder.Version
 	0,  // 1: cn.moxi.middle.bytecoder.Messagev1.version:type_name -> cn.moxi.middle.bytecoder.Version
-	8,  // 2: cn.moxi.middle.bytecoder.Messagev1.header:type_name -> cn.moxi.middle.bytecoder.Messagev1.HeaderEntry
+	9,  // 2: cn.moxi.middle.bytecoder.Messagev1.header:type_name -> cn.moxi.middle.bytecoder.Messagev1.HeaderEntry
 	0,  // 3: cn.moxi.middle.bytecoder.Messagev2.version:type_name -> cn.moxi.middle.bytecoder.Version
-	9,  // 4: cn.moxi.middle.bytecoder.Messagev2.header:type_name -> cn.moxi.middle.bytecoder.Messagev2.HeaderEntry
+	10, // 4: cn.moxi.middle.bytecoder.Messagev2.header:type_name -> cn.moxi.middle.bytecoder.Messagev2.HeaderEntry
 	0,  // 5: cn.moxi.middle.bytecoder.MessageCMD.version:type_name -> cn.moxi.middle.bytecoder.Version
 	1,  // 6: cn.moxi.middle.bytecoder.MessageCMD.cmd:type_name -> cn.moxi.middle.bytecoder.MsgLocalCmd
 	0,  // 7: cn.moxi.middle.bytecoder.Messagev0.version:type_name -> cn.moxi.middle.bytecoder.Version
-	10, // 8: cn.moxi.middle.bytecoder.Messagev0.header:type_name -> cn.moxi.middle.bytecoder.Messagev0.HeaderEntry
+	11, // 8: cn.moxi.middle.bytecoder.Messagev0.header:type_name -> cn.moxi.middle.bytecoder.Messagev0.HeaderEntry
 	9,  // [9:9] is the sub-list for method output_type
 	9,  // [9:9] is the sub-list for method input_type
 	9,  // [9:9] is the sub-list for extension type_name
@@ -806,6 +927,18 @@ func file_message_proto_init() {
 				return nil
 			}
 		}
+		file_message_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MessageSessionInfo); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -813,7 +946,7 @@ func file_message_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_message_proto_rawDesc,
 			NumEnums:      2,
-			NumMessages:   9,
+			NumMessages:   10,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
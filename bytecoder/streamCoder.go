@@ -18,6 +18,22 @@ func (c *StreamCoder) UnmarshalV1() (*Messagev1, error) {
 	return &msg, nil
 }
 
+func MarshalV1(reqId int64, method string, route string, body []byte, header map[string]string, responseHeader bool) StreamCoder {
+	if bt, err := proto.Marshal(&Messagev1{
+		Version:        Version_VERSION_1,
+		RequestId:      reqId,
+		Method:         method,
+		Route:          route,
+		Body:           body,
+		Header:         header,
+		ResponseHeader: responseHeader,
+	}); err != nil {
+		return nil
+	} else {
+		return bt
+	}
+}
+
 func MarshalV2(reqId int64, cmd int32, body []byte, metadata map[string]string) StreamCoder {
 	if bt, err := proto.Marshal(&Messagev2{
 		Version:   Version_VERSION_2,
@@ -57,6 +73,14 @@ func MarshalV0(reqId int64, code int32, message []byte, header map[string]string
 	return c
 }
 
+func (c *StreamCoder) UnmarshalV0() (*Messagev0, error) {
+	msg := Messagev0{}
+	if err := proto.Unmarshal(*c, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
 func (c *StreamCoder) UnmarshalV2() (*Messagev2, error) {
 	msg := Messagev2{}
 	if err := proto.Unmarshal(*c, &msg); err != nil {
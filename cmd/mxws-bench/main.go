@@ -0,0 +1,230 @@
+// mxws-bench 是链接和排队场景的压测工具
+//
+// 不指定 --url 的时候会在进程内启动一个 ServerUnit，用 --ready-limit/--wait-limit 模拟限流配置
+//
+//	mxws-bench --clients 500 --ramp 100 --ready-limit 200 --wait-limit 200 --rate 2 --duration 30s
+//	mxws-bench --url ws://127.0.0.1:8080/ws --group sale --clients 1000 --ramp 200
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hnchenkai/mx-wsgo/limitcount"
+	"github.com/hnchenkai/mx-wsgo/router"
+	"github.com/hnchenkai/mx-wsgo/serverunit"
+	"github.com/hnchenkai/mx-wsgo/wsclient"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+type config struct {
+	url      string
+	group    string
+	clients  int
+	ramp     float64
+	duration time.Duration
+	rate     float64
+	method   string
+	route    string
+	body     string
+	timeout  time.Duration
+}
+
+func main() {
+	cfg := config{}
+	flag.StringVar(&cfg.url, "url", "", "websocket endpoint, empty starts an in-process server")
+	flag.StringVar(&cfg.group, "group", "bench", "connection group")
+	flag.IntVar(&cfg.clients, "clients", 100, "number of concurrent clients")
+	flag.Float64Var(&cfg.ramp, "ramp", 50, "new clients per second, 0 opens all at once")
+	flag.DurationVar(&cfg.duration, "duration", 10*time.Second, "how long each client stays connected")
+	flag.Float64Var(&cfg.rate, "rate", 0, "requests per second per accepted client, 0 disables requests")
+	flag.StringVar(&cfg.method, "method", "POST", "request method")
+	flag.StringVar(&cfg.route, "route", "/echo", "request route")
+	flag.StringVar(&cfg.body, "body", "ping", "request body")
+	flag.DurationVar(&cfg.timeout, "timeout", 5*time.Second, "timeout of each request")
+	readyLimit := flag.Int("ready-limit", 100, "in-process server: ReadyLimitFunc result, -1 is unlimited")
+	waitLimit := flag.Int("wait-limit", 100, "in-process server: WaitLimitFunc result, -1 is unlimited")
+	preAdmission := flag.Bool("pre-admission", false, "in-process server: reject with 429 before the upgrade")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if cfg.url == "" {
+		url, closeFn, err := startServer(*readyLimit, *waitLimit, *preAdmission)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "start server:", err)
+			os.Exit(1)
+		}
+		defer closeFn()
+		cfg.url = url
+		fmt.Fprintf(os.Stderr, "in-process server %s ready=%d wait=%d\n", url, *readyLimit, *waitLimit)
+	}
+
+	s := newStats()
+	start := time.Now()
+	run(ctx, &cfg, s)
+	s.report(os.Stdout, time.Since(start))
+}
+
+// 按照爬坡速率启动客户端，等所有客户端结束
+func run(ctx context.Context, cfg *config, s *stats) {
+	var interval time.Duration
+	if cfg.ramp > 0 {
+		interval = time.Duration(float64(time.Second) / cfg.ramp)
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < cfg.clients; i++ {
+		if i > 0 && interval > 0 {
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runClient(ctx, cfg, s)
+		}()
+	}
+	wg.Wait()
+}
+
+// 单个客户端 链接后保持 duration，接入后按速率发送请求
+func runClient(ctx context.Context, cfg *config, s *stats) {
+	s.add(func(s *stats) { s.clients++ })
+	ctx, cancel := context.WithTimeout(ctx, cfg.duration)
+	defer cancel()
+
+	var (
+		once      sync.Once
+		waitStart time.Time
+		lock      sync.Mutex
+		rejected  = make(chan struct{})
+	)
+	client, err := wsclient.Dial(ctx, cfg.url, &wsclient.Options{
+		Group: cfg.group,
+		OnWait: func(self, total int64) {
+			lock.Lock()
+			defer lock.Unlock()
+			if waitStart.IsZero() {
+				waitStart = time.Now()
+				s.add(func(s *stats) { s.waited++ })
+			}
+		},
+		OnAccept: func() {
+			lock.Lock()
+			defer lock.Unlock()
+			s.add(func(s *stats) {
+				s.accepted++
+				if !waitStart.IsZero() {
+					s.waitTimes = append(s.waitTimes, time.Since(waitStart))
+				}
+			})
+		},
+		OnClose: func(reason string) {
+			once.Do(func() {
+				s.add(func(s *stats) { s.rejected++ })
+				close(rejected)
+			})
+		},
+		OnDisconnect: func(err error) {
+			select {
+			case <-rejected:
+			default:
+				s.add(func(s *stats) { s.disconnects++ })
+			}
+		},
+	})
+	if err != nil {
+		if errors.Is(err, websocket.ErrBadHandshake) {
+			// 升级之前被拒绝
+			s.add(func(s *stats) { s.rejected++ })
+		} else {
+			s.add(func(s *stats) { s.dialFailed++ })
+		}
+		return
+	}
+	defer client.Close()
+
+	if err := client.WaitAccept(ctx); err != nil {
+		return
+	}
+	if cfg.rate <= 0 {
+		select {
+		case <-ctx.Done():
+		case <-client.Done():
+		}
+		return
+	}
+
+	tick := time.NewTicker(time.Duration(float64(time.Second) / cfg.rate))
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-client.Done():
+			return
+		case <-tick.C:
+			reqCtx, reqCancel := context.WithTimeout(ctx, cfg.timeout)
+			begin := time.Now()
+			resp, err := client.Request(reqCtx, cfg.method, cfg.route, []byte(cfg.body), nil)
+			reqCancel()
+			if ctx.Err() != nil {
+				// 压测结束时被取消的请求不计入
+				return
+			}
+			var code int32
+			if resp != nil {
+				code = resp.GetCode()
+			}
+			s.request(code, time.Since(begin), err)
+		}
+	}
+}
+
+// 启动进程内的服务，/echo 原样返回消息体
+func startServer(readyLimit, waitLimit int, preAdmission bool) (string, func(), error) {
+	r := router.New()
+	r.Handle("", "/echo", func(ctx context.Context, msg *wsmessage.WSMessage) (int32, []byte, map[string]string, error) {
+		return http.StatusOK, msg.Message, nil, nil
+	})
+	var opts []serverunit.Option
+	if preAdmission {
+		opts = append(opts, serverunit.WithPreUpgradeAdmission(time.Second))
+	}
+	unit := serverunit.NewServerUnit(r.Dispatch, &limitcount.LimitOption{
+		Namekey: "bench",
+		ReadyLimitFunc: func(limitkey string) int {
+			return readyLimit
+		},
+		WaitLimitFunc: func(limitkey string) int {
+			return waitLimit
+		},
+	}, opts...)
+	go unit.Run()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	svr := &http.Server{Handler: unit}
+	go svr.Serve(ln)
+	return "ws://" + ln.Addr().String() + "/ws", func() {
+		svr.Close()
+		unit.Close()
+	}, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 压测过程中的统计
+type stats struct {
+	lock sync.Mutex
+
+	clients     int
+	dialFailed  int
+	accepted    int
+	waited      int
+	rejected    int
+	disconnects int
+
+	waitTimes []time.Duration
+
+	requests  int
+	failed    int
+	codes     map[int32]int
+	latencies []time.Duration
+}
+
+func newStats() *stats {
+	return &stats{codes: make(map[int32]int)}
+}
+
+func (s *stats) add(fn func(s *stats)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fn(s)
+}
+
+func (s *stats) request(code int32, latency time.Duration, err error) {
+	s.add(func(s *stats) {
+		s.requests++
+		if err != nil {
+			s.failed++
+			return
+		}
+		s.codes[code]++
+		s.latencies = append(s.latencies, latency)
+	})
+}
+
+func ratio(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(total)
+}
+
+// 计算分位数
+func percentiles(values []time.Duration) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	sorted := append([]time.Duration(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	at := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))].Round(time.Microsecond)
+	}
+	return fmt.Sprintf("p50=%s p90=%s p99=%s max=%s", at(0.5), at(0.9), at(0.99), sorted[len(sorted)-1].Round(time.Microsecond))
+}
+
+func (s *stats) report(w io.Writer, elapsed time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	fmt.Fprintf(w, "duration     %s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "clients      %d\n", s.clients)
+	fmt.Fprintf(w, "  accepted   %d (%.1f%%)\n", s.accepted, ratio(s.accepted, s.clients))
+	fmt.Fprintf(w, "  waited     %d (%.1f%%)\n", s.waited, ratio(s.waited, s.clients))
+	fmt.Fprintf(w, "  rejected   %d (%.1f%%)\n", s.rejected, ratio(s.rejected, s.clients))
+	fmt.Fprintf(w, "  dial error %d (%.1f%%)\n", s.dialFailed, ratio(s.dialFailed, s.clients))
+	fmt.Fprintf(w, "  disconnect %d\n", s.disconnects)
+	fmt.Fprintf(w, "wait time    %s\n", percentiles(s.waitTimes))
+	fmt.Fprintf(w, "requests     %d (%.1f/s) errors=%d\n", s.requests, float64(s.requests)/elapsed.Seconds(), s.failed)
+	codes := make([]int32, 0, len(s.codes))
+	for code := range s.codes {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	for _, code := range codes {
+		fmt.Fprintf(w, "  code %d    %d\n", code, s.codes[code])
+	}
+	fmt.Fprintf(w, "latency      %s\n", percentiles(s.latencies))
+}
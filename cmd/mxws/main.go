@@ -0,0 +1,304 @@
+// mxws 是调试网关用的命令行客户端
+//
+//	mxws --url ws://127.0.0.1:8080/ws --group test -H "Mx-Ws-Uid: 1" --method POST --route /foo --body @body.json
+//	mxws --url ws://127.0.0.1:8080/ws --file requests.json
+//	mxws --url ws://127.0.0.1:8080/ws                      # 交互模式，每行一个请求: METHOD ROUTE [BODY|@file]
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
+	"github.com/hnchenkai/mx-wsgo/wsclient"
+)
+
+// 可以重复的参数
+type headerFlag []string
+
+func (h *headerFlag) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlag) Set(v string) error {
+	if !strings.Contains(v, ":") {
+		return fmt.Errorf("header %q should be Key: Value", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+func (h headerFlag) parse() map[string]string {
+	out := make(map[string]string, len(h))
+	for _, v := range h {
+		k, val, _ := strings.Cut(v, ":")
+		out[strings.TrimSpace(k)] = strings.TrimSpace(val)
+	}
+	return out
+}
+
+// 文件中的请求
+type request struct {
+	Method         string            `json:"method"`
+	Route          string            `json:"route"`
+	Header         map[string]string `json:"header"`
+	Body           json.RawMessage   `json:"body"`
+	ResponseHeader bool              `json:"responseHeader"`
+}
+
+// 字符串原样发送，其他的json值按json发送
+func (r *request) body() []byte {
+	var s string
+	if err := json.Unmarshal(r.Body, &s); err == nil {
+		return []byte(s)
+	}
+	return r.Body
+}
+
+func main() {
+	var connHeaders, reqHeaders headerFlag
+	url := flag.String("url", "ws://127.0.0.1:8080/ws", "websocket endpoint of the ServerUnit")
+	group := flag.String("group", "", "connection group")
+	flag.Var(&connHeaders, "H", "connection header `Key: Value`, Mx-Ws- headers are forwarded to the server (repeatable)")
+	method := flag.String("method", "GET", "request method")
+	route := flag.String("route", "", "request route, send a single request and exit")
+	body := flag.String("body", "", "request body, @file reads from a file, @- reads from stdin")
+	flag.Var(&reqHeaders, "header", "request header `Key: Value` (repeatable)")
+	responseHeader := flag.Bool("response-header", false, "ask the server to echo response headers")
+	file := flag.String("file", "", "JSON file with a request object or an array of requests")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of each request")
+	acceptTimeout := flag.Duration("accept-timeout", 0, "max time to wait for accept, 0 waits forever")
+	reconnect := flag.Bool("reconnect", false, "reconnect when the connection is lost")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	header := http.Header{}
+	for k, v := range connHeaders.parse() {
+		header.Add(k, v)
+	}
+	client, err := wsclient.Dial(ctx, *url, &wsclient.Options{
+		Header:    header,
+		Group:     *group,
+		Reconnect: *reconnect,
+		OnAccept: func() {
+			logf("accept")
+		},
+		OnWaitInfo: func(info *bytecoder.MessageWaitInfo) {
+			logWait(info)
+		},
+		OnClose: func(reason string) {
+			logf("close reason=%q", reason)
+		},
+		OnResync: func() {
+			logf("resync, messages were lost while disconnected")
+		},
+		OnMessage: func(msg *bytecoder.Messagev0) {
+			logf("message")
+			printResponse(msg)
+		},
+		OnCmd: func(msg *bytecoder.MessageCMD) {
+			logf("cmd %s request_id=%d body=%q", msg.GetCmd(), msg.GetRequestId(), msg.GetBody())
+		},
+		OnDisconnect: func(err error) {
+			logf("disconnect: %v", err)
+		},
+	})
+	if err != nil {
+		fatalf("dial %s: %v", *url, err)
+	}
+	defer client.Close()
+	logf("connected %s", *url)
+
+	waitCtx := ctx
+	if *acceptTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, *acceptTimeout)
+		defer cancel()
+	}
+	if err := client.WaitAccept(waitCtx); err != nil {
+		fatalf("wait accept: %v", err)
+	}
+
+	send := func(req *request) {
+		reqCtx, cancel := context.WithTimeout(ctx, *timeout)
+		defer cancel()
+		start := time.Now()
+		resp, err := client.RequestWithOptions(reqCtx, req.Method, req.Route, req.body(), req.Header, req.ResponseHeader)
+		if err != nil {
+			logf("%s %s: %v", req.Method, req.Route, err)
+			return
+		}
+		logf("%s %s %d %s", req.Method, req.Route, resp.GetCode(), time.Since(start).Round(time.Microsecond))
+		printResponse(resp)
+	}
+
+	switch {
+	case *file != "":
+		reqs, err := readFile(*file)
+		if err != nil {
+			fatalf("read %s: %v", *file, err)
+		}
+		for _, req := range reqs {
+			send(req)
+		}
+	case *route != "":
+		data, err := readBody(*body)
+		if err != nil {
+			fatalf("read body: %v", err)
+		}
+		raw, _ := json.Marshal(string(data))
+		send(&request{Method: *method, Route: *route, Header: reqHeaders.parse(), Body: raw, ResponseHeader: *responseHeader})
+	default:
+		interactive(ctx, client, send, reqHeaders.parse(), *responseHeader)
+	}
+}
+
+// 交互模式 每行一个请求 METHOD ROUTE [BODY|@file]，wait 查询排队信息
+func interactive(ctx context.Context, client *wsclient.Client, send func(*request), header map[string]string, responseHeader bool) {
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-client.Done():
+			return
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if line == "wait" {
+				info, err := client.QueryWait(ctx)
+				if err != nil {
+					logf("wait: %v", err)
+					continue
+				}
+				logWait(info)
+				continue
+			}
+			parts := strings.SplitN(line, " ", 3)
+			if len(parts) < 2 {
+				logf("usage: METHOD ROUTE [BODY|@file] or wait")
+				continue
+			}
+			var data []byte
+			if len(parts) == 3 {
+				var err error
+				if data, err = readBody(parts[2]); err != nil {
+					logf("read body: %v", err)
+					continue
+				}
+			}
+			raw, _ := json.Marshal(string(data))
+			send(&request{Method: strings.ToUpper(parts[0]), Route: parts[1], Header: header, Body: raw, ResponseHeader: responseHeader})
+		}
+	}
+}
+
+func readBody(body string) ([]byte, error) {
+	switch {
+	case body == "@-":
+		return io.ReadAll(os.Stdin)
+	case strings.HasPrefix(body, "@"):
+		return os.ReadFile(body[1:])
+	default:
+		return []byte(body), nil
+	}
+}
+
+// 文件里面可以是一个请求，也可以是请求数组
+func readFile(name string) ([]*request, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	data = []byte(strings.TrimSpace(string(data)))
+	if len(data) == 0 {
+		return nil, errors.New("empty file")
+	}
+	var reqs []*request
+	if data[0] == '[' {
+		err = json.Unmarshal(data, &reqs)
+	} else {
+		req := &request{}
+		err = json.Unmarshal(data, req)
+		reqs = append(reqs, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, req := range reqs {
+		if req.Method == "" {
+			req.Method = http.MethodGet
+		}
+		if req.Route == "" {
+			return nil, errors.New("request without route")
+		}
+	}
+	return reqs, nil
+}
+
+// 格式化输出应答，消息体是json的时候直接展开
+func printResponse(msg *bytecoder.Messagev0) {
+	out := struct {
+		RequestId int64             `json:"requestId"`
+		Code      int32             `json:"code"`
+		Header    map[string]string `json:"header,omitempty"`
+		Message   interface{}       `json:"message,omitempty"`
+	}{
+		RequestId: msg.GetRequestId(),
+		Code:      msg.GetCode(),
+		Header:    msg.GetHeader(),
+	}
+	if body := msg.GetMessage(); len(body) > 0 {
+		if json.Valid(body) {
+			out.Message = json.RawMessage(body)
+		} else {
+			out.Message = string(body)
+		}
+	}
+	data, _ := json.MarshalIndent(out, "", "  ")
+	fmt.Println(string(data))
+}
+
+func logf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "%s "+format+"\n", append([]interface{}{time.Now().Format("15:04:05.000")}, args...)...)
+}
+
+// 打印排队信息，还不能估算的时候不打印等待时间
+func logWait(info *bytecoder.MessageWaitInfo) {
+	if info.GetEtaSeconds() < 0 {
+		logf("wait position=%d total=%d", info.GetSelf(), info.GetTotal())
+		return
+	}
+	logf("wait position=%d total=%d eta=%ds throughput=%.2f/s", info.GetSelf(), info.GetTotal(), info.GetEtaSeconds(), info.GetThroughput())
+}
+
+func fatalf(format string, args ...interface{}) {
+	logf(format, args...)
+	os.Exit(1)
+}
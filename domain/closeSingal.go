@@ -1,16 +1,21 @@
 package domain
 
+import "sync"
+
 // 关闭信号，专门用于安全关闭系统
 type CloseSingal struct {
-	sigCloseBegin chan bool // 等待关闭的信号
-	sigCloseEnd   chan bool // 发起关闭的人等待关闭完成的信号
-	closed        bool      // 关闭与否的状态
+	sigCloseBegin chan bool     // 等待关闭的信号
+	sigCloseEnd   chan bool     // 发起关闭的人等待关闭完成的信号
+	done          chan struct{} // 广播的关闭信号，发起关闭的时候关闭
+	once          sync.Once
+	closed        bool // 关闭与否的状态
 }
 
 func NewCloseSingal() *CloseSingal {
 	sig := CloseSingal{}
 	sig.sigCloseBegin = make(chan bool, 1)
 	sig.sigCloseEnd = make(chan bool, 1)
+	sig.done = make(chan struct{})
 	return &sig
 }
 
@@ -24,10 +29,16 @@ func (p *CloseSingal) Wait() bool {
 	}
 }
 
+// 只有一个，只能给负责调用 Defer 的协程使用
 func (p *CloseSingal) WaitSingal() chan bool {
 	return p.sigCloseBegin
 }
 
+// Done 广播的关闭信号，其他需要感知关闭的协程使用，不会抢走 WaitSingal 的信号
+func (p *CloseSingal) Done() <-chan struct{} {
+	return p.done
+}
+
 // 在wait后面调用defer
 func (p *CloseSingal) Defer() {
 	p.closed = true
@@ -39,6 +50,9 @@ func (p *CloseSingal) Close() {
 	if p.closed {
 		return
 	}
+	p.once.Do(func() {
+		close(p.done)
+	})
 	p.sigCloseBegin <- true
 	<-p.sigCloseEnd
 
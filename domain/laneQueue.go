@@ -0,0 +1,204 @@
+package domain
+
+import "sync"
+
+// 带优先级通道的队列，通道下标越大优先级越高
+// 出队按照权重做平滑的加权轮询，高优先级的先出，低优先级的也不会一直等下去
+type LaneQueue struct {
+	lanes   [][]interface{}
+	weights []int
+	// 平滑加权轮询的当前值
+	current []int
+	lock    *sync.Mutex
+}
+
+// NewLaneQueue 每个通道的权重，为空的时候只有一个通道
+func NewLaneQueue(weights ...int) *LaneQueue {
+	if len(weights) == 0 {
+		weights = []int{1}
+	}
+	w := make([]int, len(weights))
+	for i, v := range weights {
+		if v <= 0 {
+			v = 1
+		}
+		w[i] = v
+	}
+	return &LaneQueue{
+		lanes:   make([][]interface{}, len(w)),
+		weights: w,
+		current: make([]int, len(w)),
+		lock:    &sync.Mutex{},
+	}
+}
+
+// 超出范围的通道按照最近的处理
+func (q *LaneQueue) clamp(lane int) int {
+	if lane < 0 {
+		return 0
+	}
+	if lane >= len(q.lanes) {
+		return len(q.lanes) - 1
+	}
+	return lane
+}
+
+// 选出下一个出队的通道，没有元素的时候返回-1
+func (q *LaneQueue) next(sizes []int, current []int) int {
+	best, total := -1, 0
+	for i := len(sizes) - 1; i >= 0; i-- {
+		if sizes[i] == 0 {
+			continue
+		}
+		current[i] += q.weights[i]
+		total += q.weights[i]
+		if best < 0 || current[i] > current[best] {
+			best = i
+		}
+	}
+	if best >= 0 {
+		current[best] -= total
+	}
+	return best
+}
+
+// 按照出队的顺序遍历，fn 返回false停止
+func (q *LaneQueue) each(fn func(ele interface{}, lane int) bool) {
+	sizes := make([]int, len(q.lanes))
+	for i, v := range q.lanes {
+		sizes[i] = len(v)
+	}
+	current := append([]int{}, q.current...)
+	for {
+		lane := q.next(sizes, current)
+		if lane < 0 {
+			return
+		}
+		ele := q.lanes[lane][len(q.lanes[lane])-sizes[lane]]
+		sizes[lane]--
+		if !fn(ele, lane) {
+			return
+		}
+	}
+}
+
+func (q *LaneQueue) Size() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	size := 0
+	for _, v := range q.lanes {
+		size += len(v)
+	}
+	return size
+}
+
+// Add 放入最低优先级的通道
+func (q *LaneQueue) Add(ele interface{}) {
+	q.AddLane(ele, 0)
+}
+
+// AddLane 放入指定通道的队尾
+func (q *LaneQueue) AddLane(ele interface{}, lane int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	lane = q.clamp(lane)
+	q.lanes[lane] = append(q.lanes[lane], ele)
+}
+
+// UnshiftLane 放回指定通道的队首
+func (q *LaneQueue) UnshiftLane(ele interface{}, lane int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	lane = q.clamp(lane)
+	q.lanes[lane] = append([]interface{}{ele}, q.lanes[lane]...)
+}
+
+func (q *LaneQueue) Shift() interface{} {
+	ele, _ := q.ShiftLane()
+	return ele
+}
+
+// ShiftLane 按照权重取出下一个元素，返回元素和所在的通道
+func (q *LaneQueue) ShiftLane() (interface{}, int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	sizes := make([]int, len(q.lanes))
+	for i, v := range q.lanes {
+		sizes[i] = len(v)
+	}
+	lane := q.next(sizes, q.current)
+	if lane < 0 {
+		// 队列空了，重新开始轮询
+		for i := range q.current {
+			q.current[i] = 0
+		}
+		return nil, -1
+	}
+	ele := q.lanes[lane][0]
+	q.lanes[lane] = q.lanes[lane][1:]
+	return ele, lane
+}
+
+// Lane 元素所在的通道，不存在返回-1
+func (q *LaneQueue) Lane(ele interface{}) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for lane, elements := range q.lanes {
+		for _, v := range elements {
+			if v == ele {
+				return lane
+			}
+		}
+	}
+	return -1
+}
+
+// Del 删除元素，返回元素是否存在
+func (q *LaneQueue) Del(ele interface{}) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for lane, elements := range q.lanes {
+		for i, v := range elements {
+			if v == ele {
+				q.lanes[lane] = append(elements[:i:i], elements[i+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IndexOf 按照出队顺序查找元素的位置 并且返回总大小
+func (q *LaneQueue) IndexOf(ele interface{}) (int64, int64) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	var index, size int64 = -1, 0
+	for _, v := range q.lanes {
+		size += int64(len(v))
+	}
+	if size == 0 {
+		return -1, 0
+	}
+	var i int64
+	q.each(func(v interface{}, lane int) bool {
+		if v == ele {
+			index = i
+			return false
+		}
+		i++
+		return true
+	})
+	return index, size
+}
+
+// Values 按照出队顺序的快照
+func (q *LaneQueue) Values() []interface{} {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	values := []interface{}{}
+	q.each(func(v interface{}, lane int) bool {
+		values = append(values, v)
+		return true
+	})
+	return values
+}
@@ -0,0 +1,41 @@
+package domain_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hnchenkai/mx-wsgo/domain"
+)
+
+func TestLaneQueue(t *testing.T) {
+	q := domain.NewLaneQueue(1, 3)
+	for i := 0; i < 4; i++ {
+		q.AddLane(fmt.Sprint("s", i), 0)
+		q.AddLane(fmt.Sprint("p", i), 1)
+	}
+	q.AddLane("x", 5)
+
+	values := []string{}
+	for _, v := range q.Values() {
+		values = append(values, v.(string))
+	}
+	// 高优先级的先出，普通的每4个里面分到1个，超出范围的通道按最高的处理
+	want := "p0,p1,s0,p2,p3,x,s1,s2,s3"
+	if got := strings.Join(values, ","); got != want {
+		t.Fatalf("unexpected order %s", got)
+	}
+	if index, total := q.IndexOf("s1"); index != 6 || total != 9 {
+		t.Fatalf("unexpected index %d %d", index, total)
+	}
+
+	// 出队顺序和位置一致
+	for i, v := range values {
+		if ele := q.Shift(); ele != v {
+			t.Fatalf("shift %d got %v want %s", i, ele, v)
+		}
+	}
+	if q.Size() != 0 || q.Shift() != nil {
+		t.Fatal("queue not empty")
+	}
+}
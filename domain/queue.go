@@ -15,13 +15,15 @@ func NewQueue() *Queue {
 }
 
 func (q *Queue) Size() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
 	return len(q.elements)
 }
 
 func (q *Queue) Pop() (ele interface{}) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
-	size := q.Size()
+	size := len(q.elements)
 	if size == 0 {
 		return nil
 	}
@@ -34,7 +36,7 @@ func (q *Queue) Pop() (ele interface{}) {
 func (q *Queue) IndexOf(ele interface{}) (int64, int64) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
-	size := q.Size()
+	size := len(q.elements)
 	if size == 0 {
 		return -1, 0
 	}
@@ -49,7 +51,7 @@ func (q *Queue) IndexOf(ele interface{}) (int64, int64) {
 func (q *Queue) Shift() (ele interface{}) {
 	q.lock.Lock()
 	defer q.lock.Unlock()
-	size := q.Size()
+	size := len(q.elements)
 	if size == 0 {
 		return nil
 	}
@@ -65,14 +67,24 @@ func (q *Queue) Add(ele interface{}) {
 	q.elements = append(q.elements, ele)
 }
 
-func (q *Queue) Del(ele interface{}) {
+// Del 删除元素，返回元素是否存在
+func (q *Queue) Del(ele interface{}) bool {
 	q.lock.Lock()
 	defer q.lock.Unlock()
 
 	for i, v := range q.elements {
 		if v == ele {
 			q.elements = append(q.elements[:i], q.elements[i+1:]...)
-			return
+			return true
 		}
 	}
+	return false
+}
+
+// Values 按顺序返回所有元素的拷贝
+func (q *Queue) Values() []interface{} {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return append([]interface{}{}, q.elements...)
 }
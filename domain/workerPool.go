@@ -0,0 +1,235 @@
+package domain
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/hnchenkai/mx-wsgo/logger"
+)
+
+type poolTask struct {
+	fn func()
+	// 是否占用了排队名额
+	bounded bool
+}
+
+var (
+	ErrPoolClosed = errors.New("worker pool closed")
+	ErrPoolFull   = errors.New("worker pool full")
+)
+
+// 同一个key的串行队列
+type serialQueue struct {
+	key   string
+	tasks []poolTask
+	// 占用排队名额的任务数量
+	bounded int
+	// 等待这个key空出名额的数量
+	waiting int
+}
+
+// 有界的协程池，同一个key的任务按提交顺序串行执行，不同key之间并发执行
+type WorkerPool struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	ready  []*serialQueue
+	queues map[string]*serialQueue
+	slots  chan struct{}
+	// 每个key排队任务的上限，TrySubmit 使用
+	keyLimit int
+	keyCond  *sync.Cond
+	done     chan struct{}
+	closed   bool
+	wg       sync.WaitGroup
+	logger   logger.Logger
+}
+
+// 每个key默认的排队上限
+const defaultKeyLimit = 32
+
+/**
+ * @brief:  创建协程池
+ * @param:  workers 协程数量
+ * @param:  queueSize 所有key排队任务的总上限，满了之后 Submit 会阻塞
+ * @return: *WorkerPool
+ */
+func NewWorkerPool(workers int, queueSize int) *WorkerPool {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize <= 0 {
+		queueSize = workers
+	}
+	p := &WorkerPool{
+		queues:   make(map[string]*serialQueue),
+		slots:    make(chan struct{}, queueSize),
+		keyLimit: defaultKeyLimit,
+		done:     make(chan struct{}),
+		logger:   logger.Default(),
+	}
+	if p.keyLimit > queueSize {
+		p.keyLimit = queueSize
+	}
+	p.cond = sync.NewCond(&p.lock)
+	p.keyCond = sync.NewCond(&p.lock)
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+// SetLogger 设置任务panic时使用的日志，需要在提交任务之前调用
+func (p *WorkerPool) SetLogger(l logger.Logger) {
+	if l != nil {
+		p.logger = l
+	}
+}
+
+// SetKeyLimit 设置每个key排队任务的上限 默认32，不超过总上限，需要在提交任务之前调用
+func (p *WorkerPool) SetKeyLimit(n int) {
+	if n <= 0 {
+		return
+	}
+	if n > cap(p.slots) {
+		n = cap(p.slots)
+	}
+	p.keyLimit = n
+}
+
+// Submit 提交任务，排队满了会阻塞，返回false表示协程池已经关闭
+func (p *WorkerPool) Submit(key string, fn func()) bool {
+	select {
+	case p.slots <- struct{}{}:
+	case <-p.done:
+		return false
+	}
+	if !p.push(key, poolTask{fn: fn, bounded: true}) {
+		<-p.slots
+		return false
+	}
+	return true
+}
+
+// TrySubmit 提交任务，这个key排队满了的时候阻塞，等待自己的任务执行完，不影响其他key
+// 所有key的排队总数满了的时候不等待，直接返回 ErrPoolFull
+func (p *WorkerPool) TrySubmit(key string, fn func()) error {
+	p.lock.Lock()
+	for {
+		if p.closed {
+			p.lock.Unlock()
+			return ErrPoolClosed
+		}
+		q, ok := p.queues[key]
+		if !ok || q.bounded < p.keyLimit {
+			break
+		}
+		q.waiting++
+		p.keyCond.Wait()
+		q.waiting--
+	}
+	p.lock.Unlock()
+
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		return ErrPoolFull
+	}
+	if !p.push(key, poolTask{fn: fn, bounded: true}) {
+		<-p.slots
+		return ErrPoolClosed
+	}
+	return nil
+}
+
+// Enqueue 提交任务，不占用排队名额也不会阻塞，给少量的内部事件使用
+func (p *WorkerPool) Enqueue(key string, fn func()) bool {
+	return p.push(key, poolTask{fn: fn})
+}
+
+func (p *WorkerPool) push(key string, task poolTask) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return false
+	}
+	q, ok := p.queues[key]
+	if !ok {
+		q = &serialQueue{key: key}
+		p.queues[key] = q
+	}
+	q.tasks = append(q.tasks, task)
+	if task.bounded {
+		q.bounded++
+	}
+	// 只有一个任务的时候说明这个队列没有在执行中
+	if len(q.tasks) == 1 {
+		p.ready = append(p.ready, q)
+		p.cond.Signal()
+	}
+	return true
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+	for {
+		p.lock.Lock()
+		for len(p.ready) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if p.closed {
+			p.lock.Unlock()
+			return
+		}
+		q := p.ready[0]
+		p.ready = p.ready[1:]
+		task := q.tasks[0]
+		p.lock.Unlock()
+
+		p.run(task)
+
+		p.lock.Lock()
+		q.tasks = q.tasks[1:]
+		if task.bounded {
+			q.bounded--
+			if q.waiting > 0 {
+				p.keyCond.Broadcast()
+			}
+		}
+		if len(q.tasks) == 0 {
+			delete(p.queues, q.key)
+		} else {
+			// 放到最后，避免一个key占用协程太久
+			p.ready = append(p.ready, q)
+			p.cond.Signal()
+		}
+		p.lock.Unlock()
+	}
+}
+
+func (p *WorkerPool) run(task poolTask) {
+	defer func() {
+		if task.bounded {
+			<-p.slots
+		}
+		if err := recover(); err != nil {
+			p.logger.Error("worker pool task panic", "err", err)
+		}
+	}()
+	task.fn()
+}
+
+// Close 关闭协程池，没有执行的任务会被丢弃
+func (p *WorkerPool) Close() {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.done)
+	p.cond.Broadcast()
+	p.keyCond.Broadcast()
+	p.lock.Unlock()
+	p.wg.Wait()
+}
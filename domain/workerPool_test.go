@@ -0,0 +1,139 @@
+package domain_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hnchenkai/mx-wsgo/domain"
+)
+
+func TestWorkerPoolOrder(t *testing.T) {
+	pool := domain.NewWorkerPool(4, 16)
+	defer pool.Close()
+
+	const keys, tasks = 8, 200
+	var lock sync.Mutex
+	results := map[string][]int{}
+	var wg sync.WaitGroup
+	for k := 0; k < keys; k++ {
+		key := fmt.Sprint("client", k)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < tasks; i++ {
+				i := i
+				pool.Submit(key, func() {
+					lock.Lock()
+					results[key] = append(results[key], i)
+					lock.Unlock()
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lock.Lock()
+		done := 0
+		for _, v := range results {
+			if len(v) == tasks {
+				done++
+			}
+		}
+		lock.Unlock()
+		if done == keys {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("tasks not finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for key, v := range results {
+		for i, n := range v {
+			if i != n {
+				t.Fatalf("%s out of order at %d: %d", key, i, n)
+			}
+		}
+	}
+}
+
+func TestWorkerPoolBounded(t *testing.T) {
+	pool := domain.NewWorkerPool(1, 2)
+	defer pool.Close()
+
+	block := make(chan struct{})
+	var running int32
+	for i := 0; i < 2; i++ {
+		pool.Submit("a", func() {
+			atomic.AddInt32(&running, 1)
+			<-block
+		})
+	}
+
+	submitted := make(chan struct{})
+	go func() {
+		pool.Submit("b", func() {})
+		close(submitted)
+	}()
+
+	select {
+	case <-submitted:
+		t.Fatal("submit should block when the queue is full")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(block)
+	select {
+	case <-submitted:
+	case <-time.After(time.Second):
+		t.Fatal("submit still blocked after queue drained")
+	}
+}
+
+func TestWorkerPoolKeyLimit(t *testing.T) {
+	pool := domain.NewWorkerPool(1, 3)
+	pool.SetKeyLimit(2)
+	defer pool.Close()
+
+	block := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		if err := pool.TrySubmit("a", func() { <-block }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a 自己排队满了，只有 a 等待
+	submitted := make(chan error, 1)
+	go func() {
+		submitted <- pool.TrySubmit("a", func() {})
+	}()
+	select {
+	case <-submitted:
+		t.Fatal("submit should block when the key is full")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := pool.TrySubmit("b", func() {}); err != nil {
+		t.Fatal("other key should not wait:", err)
+	}
+	// 总数满了直接失败
+	if err := pool.TrySubmit("c", func() {}); err != domain.ErrPoolFull {
+		t.Fatal("expect ErrPoolFull, got", err)
+	}
+
+	close(block)
+	select {
+	case err := <-submitted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("submit still blocked after key drained")
+	}
+}
@@ -0,0 +1,131 @@
+package httpbridge
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hnchenkai/mx-wsgo/router"
+	"github.com/hnchenkai/mx-wsgo/tracing"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+// NewRequest 把 Messagev1 转换成 http 请求
+// header 使用 GetAllHeader，包含链接转发的头和请求自带的头，请求自带的头不能覆盖链接上的头
+func NewRequest(ctx context.Context, msg *wsmessage.WSMessage) (*http.Request, error) {
+	route := msg.Route
+	if !strings.HasPrefix(route, "/") {
+		route = "/" + route
+	}
+	u, err := url.ParseRequestURI(route)
+	if err != nil {
+		return nil, err
+	}
+
+	method := strings.ToUpper(msg.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(msg.Message))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = msg.GetAllHeader()
+	// 下游使用服务端的span作为父节点
+	if span := tracing.SpanFromContext(ctx); span != nil {
+		req.Header.Set(tracing.TraceParentHeader, span.TraceParent())
+	}
+	req.Host = msg.Host
+	req.RequestURI = u.RequestURI()
+	return req, nil
+}
+
+// 捕获 http 应答的 ResponseWriter
+type ResponseWriter struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func NewResponseWriter() *ResponseWriter {
+	return &ResponseWriter{
+		header: http.Header{},
+	}
+}
+
+func (w *ResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *ResponseWriter) Write(bt []byte) (int, error) {
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.body.Write(bt)
+}
+
+func (w *ResponseWriter) WriteHeader(code int) {
+	if w.code != 0 {
+		return
+	}
+	w.code = code
+}
+
+// 状态码，没有写入的时候是200
+func (w *ResponseWriter) Code() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+func (w *ResponseWriter) Body() []byte {
+	return w.body.Bytes()
+}
+
+// 应答头转换成 Messagev0 的格式，多个值用逗号连接
+func (w *ResponseWriter) FlatHeader() map[string]string {
+	return FlatHeader(w.header)
+}
+
+func FlatHeader(header http.Header) map[string]string {
+	hd := make(map[string]string, len(header))
+	for k, v := range header {
+		hd[k] = strings.Join(v, ", ")
+	}
+	return hd
+}
+
+// Handler 把 http.Handler 转换成路由的处理函数
+// 客户端设置了 response_header 的时候才会返回应答头
+func Handler(h http.Handler) router.HandlerFunc {
+	return func(ctx context.Context, msg *wsmessage.WSMessage) (int32, []byte, map[string]string, error) {
+		req, err := NewRequest(ctx, msg)
+		if err != nil {
+			return 0, nil, nil, router.NewError(http.StatusBadRequest, err.Error())
+		}
+
+		w := NewResponseWriter()
+		h.ServeHTTP(w, req)
+
+		var header map[string]string
+		if msg.ResponseHeader {
+			header = w.FlatHeader()
+			header["Content-Length"] = strconv.Itoa(len(w.Body()))
+		}
+		return int32(w.Code()), w.Body(), header, nil
+	}
+}
+
+// Dispatcher 直接作为分发器使用，所有消息都交给 http.Handler 处理
+// 其他的事件交给 event 处理，可以为空
+func Dispatcher(h http.Handler, event router.EventFunc) func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
+	r := router.New()
+	r.Handle("", "/*", Handler(h))
+	r.OnEvent(event)
+	return r.Dispatch
+}
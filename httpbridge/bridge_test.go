@@ -0,0 +1,59 @@
+package httpbridge_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
+	"github.com/hnchenkai/mx-wsgo/httpbridge"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+func TestBridge(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-User", r.Header.Get("User-Id"))
+		w.Header().Set("X-Query", r.URL.Query().Get("q"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(r.Method + " " + string(body)))
+	})
+	dispatcher := httpbridge.Dispatcher(mux, nil)
+
+	for _, responseHeader := range []bool{false, true} {
+		var sent []byte
+		msg := &wsmessage.WSMessage{
+			OrgHeader: http.Header{"User-Id": []string{"u1"}},
+			Send: func(message []byte) bool {
+				sent = message
+				return true
+			},
+		}
+		frame := bytecoder.MarshalV1(1, "PUT", "/echo?q=abc", []byte("hello"), map[string]string{"Content-Type": "text/plain"}, responseHeader)
+		frame.Gzip()
+		frame.EncodeWS()
+		if err := msg.FromPb(frame); err != nil {
+			t.Fatal(err)
+		}
+		dispatcher(wsmessage.CmdMessage, msg)
+
+		coder := bytecoder.StreamCoder(sent)
+		coder.DecodeWS()
+		coder.UnGzip()
+		resp, err := coder.UnmarshalV0()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetCode() != http.StatusCreated || string(resp.GetMessage()) != "PUT hello" {
+			t.Fatalf("unexpected response %v", resp)
+		}
+		if responseHeader {
+			if resp.GetHeader()["X-User"] != "u1" || resp.GetHeader()["X-Query"] != "abc" {
+				t.Fatalf("unexpected header %v", resp.GetHeader())
+			}
+		} else if len(resp.GetHeader()) != 0 {
+			t.Fatalf("header should not be returned %v", resp.GetHeader())
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hnchenkai/mx-wsgo/domain"
@@ -12,7 +14,10 @@ import (
 
 // 这里计算一下redis key为中心的限制模式
 type LimitStatic struct {
-	allWaitQueue map[string]*domain.Queue
+	allWaitQueue map[string]waitQueue
+	queueLock    sync.RWMutex
+	// 全局排队模式使用的有序集合，为空的时候每个节点单独排队
+	globalClient redis.IZSet
 
 	limitTtlClient redis.IHash
 	closeFd        *domain.CloseSingal
@@ -25,6 +30,24 @@ type LimitStatic struct {
 	// 心跳信息
 	waitReadyInterval time.Duration
 
+	// 排队优先级通道的权重，下标是优先级
+	laneWeights []int
+
+	// 推送排队位置的间隔和每批的数量
+	positionInterval time.Duration
+	positionBatch    int
+	// 已经推送给客户端的位置 clientId -> [3]int64{self, total, eta}
+	pushed sync.Map
+	// 同一时间只有一轮推送
+	pushing int32
+
+	// 每个限流key本节点的接入速度，同步到redis里面计算整个集群的
+	rates      map[string]*rate
+	rateLock   sync.Mutex
+	rateClient redis.IHash
+	// 接入速度的平滑窗口 默认1分钟
+	rateWindow time.Duration
+
 	parant *LimitCountUnit
 }
 
@@ -36,21 +59,36 @@ func (s *LimitStatic) init() {
 	if s.ttlInterval == 0 {
 		s.ttlInterval = 10 * time.Second
 	}
+
+	if s.rateWindow <= 0 {
+		s.rateWindow = time.Minute
+	}
+	if s.rates == nil {
+		s.rates = make(map[string]*rate)
+	}
 }
 
 func (s *LimitStatic) getAll(ctx context.Context) (map[string]string, error) {
-	return s.limitTtlClient.GetAll(ctx, s.ttlKey)
+	all, err := s.limitTtlClient.GetAll(ctx, s.ttlKey)
+	if err != nil {
+		s.parant.logger.Error("read gate ttl failed", "gate_key", s.gateKey, "err", err)
+	}
+	return all, err
 }
 
 func (s *LimitStatic) del(ctx context.Context, outttls ...string) {
 	if len(outttls) > 0 {
-		s.limitTtlClient.Del(ctx, s.ttlKey, outttls...)
+		if err := s.limitTtlClient.Del(ctx, s.ttlKey, outttls...); err != nil {
+			s.parant.logger.Error("delete expired gate failed", "gate_key", s.gateKey, "expired", outttls, "err", err)
+		}
 	}
 }
 
 // 激活当前的节点
 func (s *LimitStatic) doActiveUnit() {
-	s.limitTtlClient.Set(context.Background(), s.ttlKey, s.gateKey, fmt.Sprint(time.Now().Unix()))
+	if err := s.limitTtlClient.Set(context.Background(), s.ttlKey, s.gateKey, fmt.Sprint(time.Now().Unix())); err != nil {
+		s.parant.logger.Error("refresh gate ttl failed", "gate_key", s.gateKey, "err", err)
+	}
 }
 
 // RunTtl负责定时同步redis中的key状态，负责wait的转换到ready
@@ -58,6 +96,13 @@ func (s *LimitStatic) Run() {
 	// redis
 	tick := time.NewTimer(0)
 	tickUp := time.NewTimer(s.waitReadyInterval)
+	// 关闭推送的时候 posC 为空，这个分支永远不会执行
+	var tickPos *time.Timer
+	var posC <-chan time.Time
+	if s.positionInterval > 0 {
+		tickPos = time.NewTimer(s.positionInterval)
+		posC = tickPos.C
+	}
 	close := false
 	for {
 		if close {
@@ -67,17 +112,23 @@ func (s *LimitStatic) Run() {
 		case <-tick.C:
 			// 刷新服务的有效期
 			s.doActiveUnit()
-			tick.Reset(s.ttlInterval * time.Second)
+			if s.globalClient != nil {
+				go s.sweepGlobal()
+			}
+			tick.Reset(s.ttlInterval)
 		case <-tickUp.C:
 			// 单独一个协程负责更新
 			if s.parant.getMsgFunc != nil {
 				go s.RunAllocWaitToReady()
 				tickUp.Reset(s.waitReadyInterval)
 			}
+		case <-posC:
+			if s.parant.getMsgFunc != nil {
+				go s.RunPushPosition()
+			}
+			tickPos.Reset(s.positionInterval)
 		case <-s.closeFd.WaitSingal():
 			close = true
-		default:
-			time.Sleep(100 * time.Millisecond)
 		}
 
 	}
@@ -88,20 +139,56 @@ func (s *LimitStatic) CloseRun() {
 	s.closeFd.Close()
 }
 
-func (s *LimitStatic) getWaitQueue(limitkey string) *domain.Queue {
+func (s *LimitStatic) getWaitQueue(limitkey string) waitQueue {
+	s.queueLock.RLock()
 	unit, ok := s.allWaitQueue[limitkey]
+	s.queueLock.RUnlock()
+	if ok {
+		return unit
+	}
+
+	s.queueLock.Lock()
+	defer s.queueLock.Unlock()
+	unit, ok = s.allWaitQueue[limitkey]
 	if !ok {
-		unit = domain.NewQueue()
+		if s.globalClient != nil {
+			unit = newGlobalQueue(limitkey, s.globalClient, s)
+		} else {
+			unit = &localQueue{queue: domain.NewLaneQueue(s.laneWeights...)}
+		}
 		s.allWaitQueue[limitkey] = unit
 	}
 
 	return unit
 }
 
+// 所有等待队列的快照
+func (s *LimitStatic) waitQueues() map[string]waitQueue {
+	s.queueLock.RLock()
+	defer s.queueLock.RUnlock()
+	queues := make(map[string]waitQueue, len(s.allWaitQueue))
+	for k, v := range s.allWaitQueue {
+		queues[k] = v
+	}
+	return queues
+}
+
+// 清理全局队列里面已经失效的节点的链接
+func (s *LimitStatic) sweepGlobal() {
+	ctx := context.Background()
+	for _, v := range s.waitQueues() {
+		if q, ok := v.(*globalQueue); ok {
+			q.sweep(ctx)
+		}
+	}
+}
+
 // 有好多活动，每个活动都是不同的通道，需要单独更新
 func (s *LimitStatic) RunAllocWaitToReady() {
-	for k, v := range s.allWaitQueue {
-		if v.Size() == 0 {
+	ctx := context.Background()
+	for k, v := range s.waitQueues() {
+		if v.Size(ctx) == 0 {
+			s.skipPromotions(k)
 			continue
 		}
 		s.allocWaitToReady(k, v)
@@ -109,14 +196,14 @@ func (s *LimitStatic) RunAllocWaitToReady() {
 }
 
 // 负责分配多少人从wait转reday
-func (s *LimitStatic) allocWaitToReady(limitkey string, waitQueue *domain.Queue) {
-	if waitQueue.Size() == 0 {
+func (s *LimitStatic) allocWaitToReady(limitkey string, waitQueue waitQueue) {
+	ctx := context.Background()
+	if waitQueue.Size(ctx) == 0 {
 		return
 	}
-	ctx := context.Background()
 	// 第一步判断总量
 	totalCount := s.parant.readyPool.TotalCount(ctx, limitkey)
-	limitCount := s.parant.readyPool.limitFunc(limitkey)
+	limitCount := s.parant.readyPool.limit(limitkey)
 
 	// 计算出总量
 	leftCount := limitCount - totalCount
@@ -125,30 +212,79 @@ func (s *LimitStatic) allocWaitToReady(limitkey string, waitQueue *domain.Queue)
 	//获取等待队列总量
 	waitTotalCount := s.parant.waitingPool.TotalCount(ctx, limitkey)
 
-	// 计算出可分配数量
-	allocCount := int64(math.Abs(float64(waitQueue.Size()) / float64(waitTotalCount) * float64(leftCount)))
+	// 计算出可分配数量，全局队列由队首的链接所在的节点各自接入
+	allocCount := int64(math.Abs(float64(waitQueue.Size(ctx)) / float64(waitTotalCount) * float64(leftCount)))
+	if s.globalClient != nil {
+		allocCount = int64(leftCount)
+	}
 
 	// 第三步分配 取出用户参与分配
-	var loopIndex int64
-	for loopIndex = 0; loopIndex < allocCount; loopIndex++ {
-		clientId := waitQueue.Shift()
-		if clientId == nil {
-			// 找不到了
-			break
-		}
-		sClientId := clientId.(string)
+	promoted := 0
+	defer func() {
+		s.observePromotions(ctx, limitkey, promoted)
+	}()
+	entries := waitQueue.Take(ctx, allocCount)
+	for i, entry := range entries {
+		sClientId := entry.clientId
 		msg := s.parant.getMsgFunc(sClientId)
 		if msg == nil || msg.IsAccept() {
 			continue
 		}
 		// 分配到ready
 		if s.parant.UpgrageConnStatus(ctx, limitkey) {
+			promoted++
+			s.parant.metrics.Promoted(limitkey)
+			s.parant.leaveWait(limitkey, sClientId, "accept")
 			// 通知客户端
 			msg.SetAcceptMode()
 		} else {
 			//再丢回去 然后退出操作了
-			waitQueue.Add(sClientId)
+			waitQueue.Restore(ctx, entries[i:]...)
 			break
 		}
 	}
 }
+
+// 批次之间的暂停，把一轮推送分散开
+const positionPause = 20 * time.Millisecond
+
+// 给所有排队中的链接推送最新的位置，只推送有变化的
+// 上一轮还没有推送完的时候跳过这一轮，大量排队的时候分批推送，避免每次分配之后集中发送
+func (s *LimitStatic) RunPushPosition() {
+	if !atomic.CompareAndSwapInt32(&s.pushing, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&s.pushing, 0)
+
+	ctx := context.Background()
+	sent := 0
+	for limitkey, queue := range s.waitQueues() {
+		clients, ranks, total := queue.Positions(ctx)
+		if len(clients) == 0 {
+			continue
+		}
+		rate, cluster := s.waitRates(ctx, limitkey)
+		for i, clientId := range clients {
+			info := waitInfo(ranks[i], total, rate, cluster)
+			pos := [3]int64{info.Self, info.Total, info.EtaSeconds}
+			if last, ok := s.pushed.Load(clientId); ok && last.([3]int64) == pos {
+				continue
+			}
+			msg := s.parant.getMsgFunc(clientId)
+			if msg == nil || msg.IsAccept() {
+				continue
+			}
+			s.pushed.Store(clientId, pos)
+			msg.SendWaitInfo(info)
+
+			sent++
+			if sent%s.positionBatch == 0 {
+				select {
+				case <-time.After(positionPause):
+				case <-s.closeFd.Done():
+					return
+				}
+			}
+		}
+	}
+}
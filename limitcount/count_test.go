@@ -1,11 +1,18 @@
 package limitcount_test
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
 	"github.com/hnchenkai/mx-wsgo/limitcount"
 	"github.com/hnchenkai/mx-wsgo/wsmessage"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestAdd(t *testing.T) {
@@ -21,3 +28,270 @@ func TestAdd(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+// 没有设置限量函数的时候不限制数量
+func TestNilLimitFunc(t *testing.T) {
+	limitUnit := limitcount.NewLimitCountUnit(nil)
+	limitUnit.Init(&limitcount.LimitOption{
+		ReadyLimitFunc: func(string) int { return 1 },
+	})
+	limitUnit.Run()
+	defer limitUnit.Close()
+	for i, want := range []wsmessage.LimitStatus{wsmessage.LimitAccept, wsmessage.LimitWait, wsmessage.LimitWait} {
+		status, err := limitUnit.MakeConnStatus("test", fmt.Sprint(i))
+		if err != nil || status != want {
+			t.Fatalf("unexpected status %d %s %v", i, status, err)
+		}
+	}
+
+	unlimited := limitcount.NewLimitCountUnit(nil)
+	unlimited.Init(nil)
+	unlimited.Run()
+	defer unlimited.Close()
+	for i := 0; i < 3; i++ {
+		if status, err := unlimited.MakeConnStatus("test", fmt.Sprint(i)); err != nil || status != wsmessage.LimitAccept {
+			t.Fatalf("unexpected status %d %s %v", i, status, err)
+		}
+	}
+	if got := unlimited.Status("test"); got != "ready:3,wait:0" {
+		t.Fatalf("unexpected status %s", got)
+	}
+}
+
+func TestPushPosition(t *testing.T) {
+	var lock sync.Mutex
+	pushed := map[string][]string{}
+	headers := map[string]http.Header{}
+	limitUnit := limitcount.NewLimitCountUnit(func(clientId string) *wsmessage.WSMessage {
+		lock.Lock()
+		defer lock.Unlock()
+		header, ok := headers[clientId]
+		if !ok {
+			return nil
+		}
+		return &wsmessage.WSMessage{
+			ClientId:  clientId,
+			OrgHeader: header,
+			Send: func(message []byte) bool {
+				coder := bytecoder.StreamCoder(message)
+				coder.DecodeWS()
+				coder.UnGzip()
+				msg, _ := coder.UnmarshalCmd()
+				info := &bytecoder.MessageWaitInfo{}
+				proto.Unmarshal(msg.GetBody(), info)
+				lock.Lock()
+				pushed[clientId] = append(pushed[clientId], fmt.Sprintf("%d/%d", info.GetSelf(), info.GetTotal()))
+				lock.Unlock()
+				return true
+			},
+		}
+	})
+	limitUnit.Init(&limitcount.LimitOption{
+		ReadyLimitFunc:   func(string) int { return 1 },
+		WaitLimitFunc:    func(string) int { return 10 },
+		PositionInterval: 20 * time.Millisecond,
+	})
+	limitUnit.Run()
+	defer limitUnit.Close()
+
+	for _, id := range []string{"a", "b", "c"} {
+		status, err := limitUnit.MakeConnStatus("test", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lock.Lock()
+		headers[id] = http.Header{wsmessage.WsStatusHeader: []string{string(status)}}
+		lock.Unlock()
+	}
+	get := func(id string) string {
+		time.Sleep(100 * time.Millisecond)
+		lock.Lock()
+		defer lock.Unlock()
+		return strings.Join(pushed[id], ",")
+	}
+	if v := get("c"); v != "1/2" {
+		t.Fatalf("unexpected pushes %s", v)
+	}
+	if v := get("a"); v != "" {
+		t.Fatalf("accepted client got pushes %s", v)
+	}
+
+	// 前面的人离开之后只推送一次新的位置
+	limitUnit.CloseConnStatus("test", "b", wsmessage.LimitWait)
+	if v := get("c"); v != "1/2,0/1" {
+		t.Fatalf("unexpected pushes %s", v)
+	}
+}
+
+// 推送位置的批次之间正在暂停的时候关闭，Close 不能被卡住
+func TestClosePushPosition(t *testing.T) {
+	for round := 0; round < 5; round++ {
+		limitUnit := limitcount.NewLimitCountUnit(func(clientId string) *wsmessage.WSMessage {
+			msg := emptyMsg(clientId)
+			msg.OrgHeader.Set(wsmessage.WsStatusHeader, string(wsmessage.LimitWait))
+			return msg
+		})
+		limitUnit.Init(&limitcount.LimitOption{
+			ReadyLimitFunc:   func(string) int { return 0 },
+			WaitLimitFunc:    func(string) int { return -1 },
+			PositionInterval: time.Millisecond,
+			PositionBatch:    1,
+		})
+		limitUnit.Run()
+		for i := 0; i < 100; i++ {
+			limitUnit.MakeConnStatus("test", fmt.Sprint(i))
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		done := make(chan struct{})
+		go func() {
+			limitUnit.Close()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("close blocked in round %d", round)
+		}
+	}
+}
+
+// 可以接入的空消息
+func emptyMsg(clientId string) *wsmessage.WSMessage {
+	return &wsmessage.WSMessage{
+		ClientId:  clientId,
+		OrgHeader: http.Header{},
+		Send:      func([]byte) bool { return true },
+		AddHeader: func(string, string) bool { return true },
+		DelHeader: func(string) bool { return true },
+	}
+}
+
+func TestWaitInfoEstimate(t *testing.T) {
+	limitUnit := limitcount.NewLimitCountUnit(emptyMsg)
+	limitUnit.Init(&limitcount.LimitOption{
+		ReadyLimitFunc:   func(string) int { return 1 },
+		WaitLimitFunc:    func(string) int { return 10 },
+		PositionInterval: -1,
+	})
+	limitUnit.Run()
+	defer limitUnit.Close()
+
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := limitUnit.MakeConnStatus("test", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	if info := limitUnit.WaitInfo(ctx, "test", "c"); info.GetSelf() != 1 || info.GetEtaSeconds() != -1 {
+		t.Fatalf("unexpected info before promotion %v", info)
+	}
+
+	// 释放一个名额，下一轮分配之后按照接入速度估算
+	limitUnit.CloseConnStatus("test", "a", wsmessage.LimitAccept)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		info := limitUnit.WaitInfo(ctx, "test", "c")
+		if info.GetSelf() == 0 && info.GetThroughput() > 0 {
+			if info.GetEtaSeconds() <= 0 {
+				t.Fatalf("unexpected info after promotion %v", info)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("not promoted")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestPriorityLanes(t *testing.T) {
+	limitUnit := limitcount.NewLimitCountUnit(nil)
+	limitUnit.Init(&limitcount.LimitOption{
+		ReadyLimitFunc: func(string) int { return 1 },
+		WaitLimitFunc:  func(string) int { return 10 },
+		LaneWeights:    []int{1, 3},
+	})
+	limitUnit.Run()
+	defer limitUnit.Close()
+
+	for i, id := range []string{"a", "s1", "s2", "p1"} {
+		lane := 0
+		if i == 3 {
+			lane = 1
+		}
+		if _, err := limitUnit.MakeConnStatusPriority("test", id, lane); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 后来的高优先级链接排在前面
+	if self, total := limitUnit.WaitUnitInfo(context.Background(), "test", "p1"); self != 0 || total != 3 {
+		t.Fatalf("unexpected position %d %d", self, total)
+	}
+	if got := strings.Join(limitUnit.WaitQueues()["test"], ","); got != "p1,s1,s2" {
+		t.Fatalf("unexpected queue %s", got)
+	}
+}
+
+func TestGlobalQueue(t *testing.T) {
+	limitUnit := limitcount.NewLimitCountUnit(emptyMsg)
+	limitUnit.Init(&limitcount.LimitOption{
+		ReadyLimitFunc:   func(string) int { return 1 },
+		WaitLimitFunc:    func(string) int { return 10 },
+		PositionInterval: -1,
+		GlobalQueue:      true,
+	})
+	limitUnit.Run()
+	defer limitUnit.Close()
+
+	ctx := context.Background()
+	for _, id := range []string{"a", "b", "c", "d"} {
+		if _, err := limitUnit.MakeConnStatusPriority("test", id, 1); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	if self, total := limitUnit.WaitUnitInfo(ctx, "test", "c"); self != 1 || total != 3 {
+		t.Fatalf("unexpected position %d %d", self, total)
+	}
+	if got := strings.Join(limitUnit.WaitQueues()["test"], ","); got != "b,c,d" {
+		t.Fatalf("unexpected queue %s", got)
+	}
+
+	// 其他节点的链接排在前面，位置是整个集群的，和推送给客户端的一样
+	remove := limitcount.AddGlobalMember(limitUnit, "test", "other-gate|x", 0)
+	self, _ := limitUnit.WaitUnitInfo(ctx, "test", "c")
+	positions := limitUnit.WaitPositions()["test"]
+	if len(positions) != 3 || positions[1].ClientId != "c" || positions[1].Position != self || self != 2 {
+		t.Fatalf("unexpected positions %+v self %d", positions, self)
+	}
+	remove()
+
+	limitUnit.CloseConnStatus("test", "b", wsmessage.LimitWait)
+	if err := limitUnit.Promote(ctx, "test", "d"); err != nil {
+		t.Fatal(err)
+	}
+	if err := limitUnit.Promote(ctx, "test", "d"); err != limitcount.ErrNotWaiting {
+		t.Fatalf("unexpected promote result %v", err)
+	}
+	if got := strings.Join(limitUnit.WaitQueues()["test"], ","); got != "c" {
+		t.Fatalf("unexpected queue %s", got)
+	}
+
+	// 释放名额之后队首的链接被接入
+	limitUnit.CloseConnStatus("test", "a", wsmessage.LimitAccept)
+	limitUnit.CloseConnStatus("test", "d", wsmessage.LimitAccept)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if limitUnit.Status("test") == "ready:1,wait:0" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("not promoted %s", limitUnit.Status("test"))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if _, total := limitUnit.WaitUnitInfo(ctx, "test", "c"); total != 0 {
+		t.Fatalf("promoted client still queued %d", total)
+	}
+}
@@ -0,0 +1,15 @@
+package limitcount
+
+import "context"
+
+// 测试使用
+var FreshValidValue = freshValidValue
+
+// 模拟其他节点在全局队列里面排队的链接，返回删除的函数
+func AddGlobalMember(unit *LimitCountUnit, limitkey string, member string, score float64) func() {
+	ctx := context.Background()
+	unit.limitStatic.globalClient.Add(ctx, limitkey, member, score)
+	return func() {
+		unit.limitStatic.globalClient.Rem(ctx, limitkey, member)
+	}
+}
@@ -27,9 +27,9 @@ func freshValidValue(ttls map[string]string, limits map[string]string, limitTime
 	ttlOutKeys := []string{}
 	now := time.Now().Unix()
 	for k, v := range ttls {
-		// 这里踢掉哪些过期的
+		// 这里踢掉哪些过期的，记录的是秒，超过两个有效期没有刷新的算失效
 		iTime, _ := strconv.ParseInt(v, 10, 64)
-		if iTime+int64(limitTime)*2 < now {
+		if iTime+int64(limitTime/time.Second)*2 < now {
 			// 这些就不需要了
 			delete(ttls, k)
 			ttlOutKeys = append(ttlOutKeys, k)
@@ -80,6 +80,7 @@ func (p *LimitPool) init() {
 func (p *LimitPool) AddCount(ctx context.Context, limitkey string) (result error) {
 	limits, err := p.limitCountClient.GetAll(ctx, limitkey)
 	if err != nil {
+		p.logError("read limit pool failed", limitkey, err)
 		return err
 	}
 
@@ -99,28 +100,28 @@ func (p *LimitPool) AddCount(ctx context.Context, limitkey string) (result error
 	}
 
 	// 这里要读取配置信息，用来确定可以使用的上线
-	limitCount := 0
-	if p.limitFunc != nil {
-		defer func() {
-			if err := recover(); err != nil {
-				limitCount = 0
-				result = errors.New("limit func error")
-			}
-		}()
-		limitCount = p.limitFunc(limitkey)
-	}
+	defer func() {
+		if err := recover(); err != nil {
+			p.parant.logger.Error("limit func panic", "pool", p.name, "limit_key", limitkey, "err", err)
+			result = errors.New("limit func error")
+		}
+	}()
+	limitCount := p.limit(limitkey)
 	if limitCount >= 0 && sumTotal >= limitCount {
 		return errors.New("数量满了，请等待")
 	}
 
 	//看看自己是否超标了，看看总量是否超标了
 	if err := p.limitCountClient.Set(ctx, limitkey, p.parant.limitStatic.gateKey, fmt.Sprint(selfCount+1)); err != nil {
+		p.logError("update limit pool failed", limitkey, err)
 		return err
 	}
 	// redis.LimitCountClient.IncrBy(ctx, limitkey, gateKey, 1)
 
 	if len(outlimits) > 0 {
-		p.limitCountClient.Del(ctx, limitkey, outlimits...)
+		if err := p.limitCountClient.Del(ctx, limitkey, outlimits...); err != nil {
+			p.logError("delete expired limit failed", limitkey, err)
+		}
 	}
 
 	if len(outttls) > 0 {
@@ -130,15 +131,39 @@ func (p *LimitPool) AddCount(ctx context.Context, limitkey string) (result error
 	return nil
 }
 
+// 限量上限，没有配置限量函数的时候不限制
+func (p *LimitPool) limit(limitkey string) int {
+	if p.limitFunc == nil {
+		return -1
+	}
+	return p.limitFunc(limitkey)
+}
+
+// IncrCount 不判断上限直接增加一个数量
+func (p *LimitPool) IncrCount(ctx context.Context, limitkey string) error {
+	_, err := p.limitCountClient.IncrBy(ctx, limitkey, p.parant.limitStatic.gateKey, 1)
+	if err != nil {
+		p.logError("update limit pool failed", limitkey, err)
+	}
+	return err
+}
+
+// redis 出错的日志
+func (p *LimitPool) logError(msg string, limitkey string, err error) {
+	p.parant.logger.Error(msg, "pool", p.name, "limit_key", limitkey, "gate_key", p.parant.limitStatic.gateKey, "err", err)
+}
+
 // 移除一个数量
 func (p *LimitPool) DelCount(ctx context.Context, limitkey string) error {
 	count, err := p.limitCountClient.DecrBy(ctx, limitkey, p.parant.limitStatic.gateKey, 1)
 	if err != nil {
+		p.logError("update limit pool failed", limitkey, err)
 		return err
 	}
 
 	if count < 0 {
 		p.limitCountClient.Del(ctx, limitkey, p.parant.limitStatic.gateKey)
+		p.parant.logger.Warn("limit pool count below zero", "pool", p.name, "limit_key", limitkey, "gate_key", p.parant.limitStatic.gateKey)
 		return errors.New("limit key is out of range")
 	}
 
@@ -199,6 +224,7 @@ func (pool *PoolUnit) SelfCount() int {
 func (p *LimitPool) TotalCount(ctx context.Context, limitkey string) int {
 	limits, err := p.limitCountClient.GetAll(ctx, limitkey)
 	if err != nil {
+		p.logError("read limit pool failed", limitkey, err)
 		return 0
 	}
 
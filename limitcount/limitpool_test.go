@@ -0,0 +1,36 @@
+package limitcount_test
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hnchenkai/mx-wsgo/limitcount"
+)
+
+// 节点超过两个有效期没有刷新才算失效，失效节点的数量一起去掉
+func TestFreshValidValue(t *testing.T) {
+	now := time.Now().Unix()
+	ttls := map[string]string{
+		"live":   fmt.Sprint(now),
+		"recent": fmt.Sprint(now - 15),
+		"dead":   fmt.Sprint(now - 25),
+		"old":    fmt.Sprint(now - 3600),
+	}
+	limits := map[string]string{"live": "1", "recent": "2", "dead": "3", "gone": "4"}
+
+	outTtls, outLimits := limitcount.FreshValidValue(ttls, limits, 10*time.Second)
+	sort.Strings(outTtls)
+	sort.Strings(outLimits)
+	if got := strings.Join(outTtls, ","); got != "dead,old" {
+		t.Fatalf("unexpected expired gates %s", got)
+	}
+	if got := strings.Join(outLimits, ","); got != "dead,gone" {
+		t.Fatalf("unexpected expired limits %s", got)
+	}
+	if len(ttls) != 2 || len(limits) != 2 || limits["recent"] != "2" {
+		t.Fatalf("unexpected valid values %v %v", ttls, limits)
+	}
+}
@@ -2,12 +2,17 @@ package limitcount
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
 	"github.com/hnchenkai/mx-wsgo/domain"
 	"github.com/hnchenkai/mx-wsgo/limitcount/redis"
+	"github.com/hnchenkai/mx-wsgo/logger"
+	"github.com/hnchenkai/mx-wsgo/metrics"
 	"github.com/hnchenkai/mx-wsgo/wsmessage"
 )
 
@@ -16,16 +21,34 @@ type LimitOption struct {
 	RedisConn      redis.IRedisConn
 	Namekey        string                    // 服务的命名空间
 	TtlInterval    time.Duration             // 有效期更新时间 单位秒 ttl有效期是这个的2倍 默认是10秒
-	ReadyLimitFunc func(limitkey string) int // 链接成功状态的总量 -1表示不限制
-	WaitLimitFunc  func(limitkey string) int // 等待状态的总量 -1表示不限制
+	ReadyLimitFunc func(limitkey string) int // 链接成功状态的总量 -1表示不限制，为空也不限制
+	WaitLimitFunc  func(limitkey string) int // 等待状态的总量 -1表示不限制，为空也不限制
+
+	PositionInterval time.Duration // 给排队中的链接推送位置的间隔 默认5秒 小于0不推送
+	PositionBatch    int           // 每一批推送的链接数量，批次之间会暂停一下 默认500
+	ThroughputWindow time.Duration // 估算等待时间的接入速度的平滑窗口 默认1分钟
+	// 排队优先级通道的权重，下标是优先级，数字大的先接入，例如 []int{1, 4} 两个通道都有人排队时每5个名额普通的分1个
+	// 为空只有一个通道，按照先来后到排队
+	LaneWeights []int
+	// 开启全局排队，所有节点的排队链接按照到达时间放在redis的有序集合里面，位置是整个集群的，不支持优先级通道
+	// 失效节点的链接会根据节点的有效期清理掉
+	GlobalQueue bool
 }
 
 func (lo *LimitOption) init() {
 	if lo.TtlInterval == 0 {
 		lo.TtlInterval = 10 * time.Second
 	}
+	if lo.PositionInterval == 0 {
+		lo.PositionInterval = 5 * time.Second
+	}
+	if lo.PositionBatch <= 0 {
+		lo.PositionBatch = 500
+	}
 }
 
+var ErrNotWaiting = errors.New("client is not waiting")
+
 type IGetMessageFunc = func(clientId string) *wsmessage.WSMessage
 
 // 限流静态数据
@@ -34,12 +57,19 @@ type LimitCountUnit struct {
 	readyPool   *LimitPool
 	waitingPool *LimitPool
 	getMsgFunc  IGetMessageFunc
+
+	logger logger.Logger
+	// 指标，为空不统计
+	metrics *metrics.Metrics
+	// 进入等待队列的时间 clientId -> time.Time
+	waitSince sync.Map
 }
 
 // NewLimitCountUnit 创建一个限流单元
 func NewLimitCountUnit(u IGetMessageFunc) *LimitCountUnit {
 	unit := &LimitCountUnit{
 		getMsgFunc: u,
+		logger:     logger.Default(),
 	}
 	return unit
 }
@@ -58,8 +88,17 @@ func (unit *LimitCountUnit) Init(option *LimitOption) {
 		limitTtlClient: redis.NewRedisHash(option.RedisConn, fmt.Sprintf("%s:ttl", option.Namekey)),
 		closeFd:        domain.NewCloseSingal(),
 		gateKey:        uuid.New().String(),
-		allWaitQueue:   make(map[string]*domain.Queue),
+		allWaitQueue:   make(map[string]waitQueue),
 		parant:         unit,
+
+		positionInterval: option.PositionInterval,
+		positionBatch:    option.PositionBatch,
+		rateClient:       redis.NewRedisHash(option.RedisConn, fmt.Sprintf("%s:rate", option.Namekey)),
+		rateWindow:       option.ThroughputWindow,
+		laneWeights:      option.LaneWeights,
+	}
+	if option.GlobalQueue {
+		unit.limitStatic.globalClient = redis.NewRedisZSet(option.RedisConn, fmt.Sprintf("%s:queue", option.Namekey))
 	}
 	unit.limitStatic.init()
 	unit.readyPool = &LimitPool{
@@ -85,6 +124,124 @@ func (unit *LimitCountUnit) Status(limitkey string) string {
 	return fmt.Sprintf("ready:%d,wait:%d", ready, wait)
 }
 
+// SetLogger 设置日志
+func (unit *LimitCountUnit) SetLogger(l logger.Logger) {
+	if l != nil {
+		unit.logger = l
+	}
+}
+
+// SetMetrics 设置指标，输出指标的时候刷新限流池的数量
+func (unit *LimitCountUnit) SetMetrics(m *metrics.Metrics) {
+	unit.metrics = m
+	if m != nil {
+		m.OnCollect(func() {
+			m.SetPools(unit.Pools(context.Background()))
+		})
+	}
+}
+
+// Pools 本节点见过的所有限流key的数量
+func (unit *LimitCountUnit) Pools(ctx context.Context) []metrics.PoolStat {
+	if unit.limitStatic == nil {
+		return nil
+	}
+	queues := unit.limitStatic.waitQueues()
+	stats := make([]metrics.PoolStat, 0, len(queues))
+	for limitkey := range queues {
+		stats = append(stats, metrics.PoolStat{
+			Limitkey: limitkey,
+			Ready:    unit.readyPool.TotalCount(ctx, limitkey),
+			Wait:     unit.waitingPool.TotalCount(ctx, limitkey),
+		})
+	}
+	return stats
+}
+
+// 排队中的链接，位置和推送给客户端的 MessageWaitInfo.Self 一样，全局排队模式是整个集群的位置
+type WaitPosition struct {
+	ClientId string
+	Position int64
+}
+
+// WaitPositions 本节点的等待队列快照，按排队顺序，全局排队模式只有本节点的链接
+func (unit *LimitCountUnit) WaitPositions() map[string][]WaitPosition {
+	if unit.limitStatic == nil {
+		return nil
+	}
+	ctx := context.Background()
+	queues := make(map[string][]WaitPosition)
+	for limitkey, queue := range unit.limitStatic.waitQueues() {
+		clients, ranks, _ := queue.Positions(ctx)
+		positions := make([]WaitPosition, len(clients))
+		for i, clientId := range clients {
+			positions[i] = WaitPosition{ClientId: clientId, Position: ranks[i]}
+		}
+		queues[limitkey] = positions
+	}
+	return queues
+}
+
+// WaitQueues 本节点的等待队列快照，按排队顺序，全局排队模式只有本节点的链接
+func (unit *LimitCountUnit) WaitQueues() map[string][]string {
+	positions := unit.WaitPositions()
+	if positions == nil {
+		return nil
+	}
+	queues := make(map[string][]string, len(positions))
+	for limitkey, list := range positions {
+		clients := make([]string, len(list))
+		for i, v := range list {
+			clients[i] = v.ClientId
+		}
+		queues[limitkey] = clients
+	}
+	return queues
+}
+
+// Promote 把等待中的链接直接转为接入，不受接入数量的限制
+func (unit *LimitCountUnit) Promote(ctx context.Context, limitkey string, clientId string) error {
+	if unit.limitStatic == nil {
+		return ErrNotWaiting
+	}
+	waitQueue := unit.limitStatic.getWaitQueue(limitkey)
+	entry, ok := waitQueue.Remove(ctx, clientId)
+	if !ok {
+		return ErrNotWaiting
+	}
+	if err := unit.readyPool.IncrCount(ctx, limitkey); err != nil {
+		// 放回原来的位置，等待正常分配
+		waitQueue.Restore(ctx, entry)
+		return err
+	}
+	unit.waitingPool.DelCount(ctx, limitkey)
+	unit.metrics.Promoted(limitkey)
+	unit.leaveWait(limitkey, clientId, "accept")
+	if unit.getMsgFunc != nil {
+		if msg := unit.getMsgFunc(clientId); msg != nil {
+			msg.SetAcceptMode()
+		}
+	}
+	return nil
+}
+
+// 记录进入等待队列的时间
+func (unit *LimitCountUnit) enterWait(clientId string) {
+	if unit.metrics != nil {
+		unit.waitSince.Store(clientId, time.Now())
+	}
+}
+
+// 离开等待队列，outcome 为 accept 或者 leave
+func (unit *LimitCountUnit) leaveWait(limitkey string, clientId string, outcome string) {
+	if unit.limitStatic != nil {
+		unit.limitStatic.pushed.Delete(clientId)
+	}
+	if since, ok := unit.waitSince.LoadAndDelete(clientId); ok {
+		unit.metrics.ObserveWait(limitkey, outcome, time.Since(since.(time.Time)))
+	}
+}
+
 // Run 负责定时同步redis中的key状态，负责wait的转换到ready
 func (unit *LimitCountUnit) Run() {
 	if unit.limitStatic == nil {
@@ -108,23 +265,50 @@ func (unit *LimitCountUnit) WaitUnitInfo(ctx context.Context, limitkey string, c
 		return -1, 0
 	}
 	// 这里就按照自己的等待列表里面的数据返回
-	return unit.limitStatic.getWaitQueue(limitkey).IndexOf(clientId)
+	return unit.limitStatic.getWaitQueue(limitkey).IndexOf(ctx, clientId)
+}
+
+// WaitInfo 获取等待信息，带上预计的等待时间和接入速度
+func (unit *LimitCountUnit) WaitInfo(ctx context.Context, limitkey string, clientId string) *bytecoder.MessageWaitInfo {
+	self, total := unit.WaitUnitInfo(ctx, limitkey, clientId)
+	if unit.limitStatic == nil {
+		return waitInfo(self, total, 0, 0)
+	}
+	rate, cluster := unit.limitStatic.waitRates(ctx, limitkey)
+	return waitInfo(self, total, rate, cluster)
 }
 
 // MakeConnStatus 负责生成连接状态
 func (unit *LimitCountUnit) MakeConnStatus(limitkey string, clientId string) (wsmessage.LimitStatus, error) {
+	return unit.MakeConnStatusPriority(limitkey, clientId, 0)
+}
+
+// MakeConnStatusPriority 负责生成连接状态，需要排队的时候放入 lane 对应的优先级通道
+func (unit *LimitCountUnit) MakeConnStatusPriority(limitkey string, clientId string, lane int) (wsmessage.LimitStatus, error) {
+	status, err := unit.ReserveConnStatus(limitkey, clientId)
+	if status == wsmessage.LimitWait {
+		unit.EnqueueWait(limitkey, clientId, lane)
+	}
+	return status, err
+}
+
+// ReserveConnStatus 负责生成连接状态并占用名额，需要排队的时候先不放入等待队列
+// 用于链接还不能收到消息的时候(例如升级之前)，可以收到消息之后再调用 EnqueueWait，
+// 否则分配名额的时候找不到链接，会被当成已经断开
+func (unit *LimitCountUnit) ReserveConnStatus(limitkey string, clientId string) (wsmessage.LimitStatus, error) {
 	if unit.limitStatic == nil {
 		return wsmessage.LimitAccept, nil
 	}
 	ctx := context.Background()
 	// 优先查一下是否有人排队中，是否需要清理排队队列
 	waitQueue := unit.limitStatic.getWaitQueue(limitkey)
-	if waitQueue.Size() > 0 {
+	if waitQueue.Size(ctx) > 0 {
 		// 放入等待队列
 		if err := unit.waitingPool.AddCount(ctx, limitkey); err == nil {
-			waitQueue.Add(clientId)
+			unit.enterWait(clientId)
 			return wsmessage.LimitWait, nil
 		} else {
+			unit.metrics.Rejected(limitkey)
 			return wsmessage.LimitReject, err
 		}
 	}
@@ -132,13 +316,22 @@ func (unit *LimitCountUnit) MakeConnStatus(limitkey string, clientId string) (ws
 	if err := unit.readyPool.AddCount(ctx, limitkey); err == nil {
 		return wsmessage.LimitAccept, nil
 	} else if err := unit.waitingPool.AddCount(ctx, limitkey); err == nil {
-		waitQueue.Add(clientId)
+		unit.enterWait(clientId)
 		return wsmessage.LimitWait, nil
 	} else {
+		unit.metrics.Rejected(limitkey)
 		return wsmessage.LimitReject, err
 	}
 }
 
+// EnqueueWait 把 ReserveConnStatus 返回排队的链接放入 lane 对应的优先级通道
+func (unit *LimitCountUnit) EnqueueWait(limitkey string, clientId string, lane int) {
+	if unit.limitStatic == nil {
+		return
+	}
+	unit.limitStatic.getWaitQueue(limitkey).Add(context.Background(), clientId, lane)
+}
+
 // CloseConnStatus 负责关闭连接状态
 func (unit *LimitCountUnit) CloseConnStatus(limitkey string, clientId string, status wsmessage.LimitStatus) error {
 	if unit.limitStatic == nil {
@@ -150,7 +343,8 @@ func (unit *LimitCountUnit) CloseConnStatus(limitkey string, clientId string, st
 		return unit.readyPool.DelCount(ctx, limitkey)
 	case wsmessage.LimitWait:
 		// 从等待队列中删除
-		unit.limitStatic.getWaitQueue(limitkey).Del(clientId)
+		unit.limitStatic.getWaitQueue(limitkey).Remove(ctx, clientId)
+		unit.leaveWait(limitkey, clientId, "leave")
 		return unit.waitingPool.DelCount(ctx, limitkey)
 	case wsmessage.LimitReject:
 	}
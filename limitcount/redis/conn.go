@@ -0,0 +1,28 @@
+package redis
+
+import "github.com/go-redis/redis/v8"
+
+// redis 链接，所有的key都会加上前缀
+type RedisConn struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisConn 使用已有的客户端创建链接，prefix 为空不加前缀
+func NewRedisConn(client *redis.Client, prefix string) *RedisConn {
+	return &RedisConn{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func (c *RedisConn) rdb() *redis.Client {
+	return c.client
+}
+
+func (c *RedisConn) doPrefix(key string) string {
+	if len(c.prefix) > 0 {
+		key = c.prefix + ":" + key
+	}
+	return key
+}
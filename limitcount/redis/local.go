@@ -21,7 +21,11 @@ func (l *LocalHashUnit) Get(ctx context.Context, key string) (string, error) {
 func (l *LocalHashUnit) GetAll(ctx context.Context) (map[string]string, error) {
 	l.lock.RLock()
 	defer l.lock.RUnlock()
-	return l.data, nil
+	data := make(map[string]string, len(l.data))
+	for k, v := range l.data {
+		data[k] = v
+	}
+	return data, nil
 }
 
 func (l *LocalHashUnit) Del(ctx context.Context, subKeys ...string) error {
@@ -73,10 +77,20 @@ func (l *LocalHashUnit) DecrBy(ctx context.Context, key string, val int64) (int6
 }
 
 type LocalHash struct {
+	lock *sync.RWMutex
 	data map[string]*LocalHashUnit
 }
 
+func (l *LocalHash) unit(key string) (*LocalHashUnit, bool) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	unit, ok := l.data[key]
+	return unit, ok
+}
+
 func (l *LocalHash) getUnit(key string) *LocalHashUnit {
+	l.lock.Lock()
+	defer l.lock.Unlock()
 	unit, ok := l.data[key]
 	if !ok {
 		unit = &LocalHashUnit{
@@ -89,7 +103,7 @@ func (l *LocalHash) getUnit(key string) *LocalHashUnit {
 }
 
 func (l *LocalHash) Get(ctx context.Context, key, subKey string) (string, error) {
-	unit, ok := l.data[key]
+	unit, ok := l.unit(key)
 	if !ok {
 		return "", nil
 	}
@@ -97,7 +111,7 @@ func (l *LocalHash) Get(ctx context.Context, key, subKey string) (string, error)
 }
 
 func (l *LocalHash) GetAll(ctx context.Context, key string) (map[string]string, error) {
-	unit, ok := l.data[key]
+	unit, ok := l.unit(key)
 	if !ok {
 		return nil, nil
 	}
@@ -105,7 +119,7 @@ func (l *LocalHash) GetAll(ctx context.Context, key string) (map[string]string,
 }
 
 func (l *LocalHash) Del(ctx context.Context, key string, subKeys ...string) error {
-	unit, ok := l.data[key]
+	unit, ok := l.unit(key)
 	if !ok {
 		return nil
 	}
@@ -129,6 +143,7 @@ func (l *LocalHash) DecrBy(ctx context.Context, key string, subKey string, val i
 
 func NewLocalHash() *LocalHash {
 	return &LocalHash{
+		lock: &sync.RWMutex{},
 		data: make(map[string]*LocalHashUnit),
 	}
 }
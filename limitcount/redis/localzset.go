@@ -0,0 +1,98 @@
+package redis
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// 本地的有序集合，没有redis的时候使用
+type LocalZSet struct {
+	lock *sync.Mutex
+	data map[string][]ZMember
+}
+
+func NewLocalZSet() *LocalZSet {
+	return &LocalZSet{
+		lock: &sync.Mutex{},
+		data: make(map[string][]ZMember),
+	}
+}
+
+func indexOfMember(members []ZMember, member string) int {
+	for i, v := range members {
+		if v.Member == member {
+			return i
+		}
+	}
+	return -1
+}
+
+func (l *LocalZSet) Add(ctx context.Context, key string, member string, score float64) (bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	members := l.data[key]
+	if indexOfMember(members, member) >= 0 {
+		return false, nil
+	}
+	// 分数相同的按照成员排序，和redis一致
+	i := sort.Search(len(members), func(i int) bool {
+		return members[i].Score > score || (members[i].Score == score && members[i].Member > member)
+	})
+	members = append(members, ZMember{})
+	copy(members[i+1:], members[i:])
+	members[i] = ZMember{Member: member, Score: score}
+	l.data[key] = members
+	return true, nil
+}
+
+func (l *LocalZSet) Rem(ctx context.Context, key string, members ...string) (int64, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var count int64
+	for _, member := range members {
+		if i := indexOfMember(l.data[key], member); i >= 0 {
+			l.data[key] = append(l.data[key][:i], l.data[key][i+1:]...)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (l *LocalZSet) Score(ctx context.Context, key string, member string) (float64, bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if i := indexOfMember(l.data[key], member); i >= 0 {
+		return l.data[key][i].Score, true, nil
+	}
+	return 0, false, nil
+}
+
+func (l *LocalZSet) Rank(ctx context.Context, key string, member string) (int64, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return int64(indexOfMember(l.data[key], member)), nil
+}
+
+func (l *LocalZSet) Card(ctx context.Context, key string) (int64, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return int64(len(l.data[key])), nil
+}
+
+func (l *LocalZSet) Range(ctx context.Context, key string, start int64, stop int64) ([]ZMember, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	members := l.data[key]
+	size := int64(len(members))
+	if stop < 0 || stop >= size {
+		stop = size - 1
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > stop {
+		return nil, nil
+	}
+	return append([]ZMember{}, members[start:stop+1]...), nil
+}
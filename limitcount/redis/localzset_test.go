@@ -0,0 +1,38 @@
+package redis_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hnchenkai/mx-wsgo/limitcount/redis"
+)
+
+func TestLocalZSet(t *testing.T) {
+	ctx := context.Background()
+	z := redis.NewLocalZSet()
+	z.Add(ctx, "k", "b", 2)
+	z.Add(ctx, "k", "a", 1)
+	z.Add(ctx, "k", "c", 2)
+	if ok, _ := z.Add(ctx, "k", "a", 0); ok {
+		t.Fatal("existing member added again")
+	}
+	if rank, _ := z.Rank(ctx, "k", "c"); rank != 2 {
+		t.Fatalf("unexpected rank %d", rank)
+	}
+	if score, ok, _ := z.Score(ctx, "k", "a"); !ok || score != 1 {
+		t.Fatalf("unexpected score %v %v", score, ok)
+	}
+	members, _ := z.Range(ctx, "k", 0, 1)
+	if len(members) != 2 || members[0].Member != "a" || members[1].Member != "b" {
+		t.Fatalf("unexpected range %v", members)
+	}
+	if n, _ := z.Rem(ctx, "k", "a", "x"); n != 1 {
+		t.Fatalf("unexpected removed %d", n)
+	}
+	if size, _ := z.Card(ctx, "k"); size != 2 {
+		t.Fatalf("unexpected size %d", size)
+	}
+	if rank, _ := z.Rank(ctx, "k", "a"); rank != -1 {
+		t.Fatalf("removed member rank %d", rank)
+	}
+}
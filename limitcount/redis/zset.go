@@ -0,0 +1,111 @@
+package redis
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// 有序集合，全局排队使用
+type IZSet interface {
+	// 成员不存在的时候添加，返回是否添加成功
+	Add(ctx context.Context, key string, member string, score float64) (bool, error)
+	// 删除成员，返回删除的数量
+	Rem(ctx context.Context, key string, members ...string) (int64, error)
+	// 成员的分数，第二个返回值表示成员是否存在
+	Score(ctx context.Context, key string, member string) (float64, bool, error)
+	// 成员的排名，不存在返回-1
+	Rank(ctx context.Context, key string, member string) (int64, error)
+	Card(ctx context.Context, key string) (int64, error)
+	// 按分数从小到大取出 [start, stop] 的成员，stop 为-1表示到最后
+	Range(ctx context.Context, key string, start int64, stop int64) ([]ZMember, error)
+}
+
+func NewRedisZSet(conn IRedisConn, prefix string) IZSet {
+	if conn == nil {
+		return NewLocalZSet()
+	}
+	return &RedisZSet{
+		conn:   conn,
+		prefix: prefix,
+	}
+}
+
+type RedisZSet struct {
+	conn   IRedisConn
+	prefix string
+}
+
+func (z *RedisZSet) doPrefix(key string) string {
+	if len(z.prefix) > 0 {
+		key = z.prefix + ":" + key
+	}
+
+	return z.conn.doPrefix(key)
+}
+
+func (z *RedisZSet) Add(ctx context.Context, key string, member string, score float64) (bool, error) {
+	cmd := z.conn.rdb().ZAddNX(ctx, z.doPrefix(key), &redis.Z{Score: score, Member: member})
+	if cmd.Err() != nil {
+		return false, cmd.Err()
+	}
+	return cmd.Val() > 0, nil
+}
+
+func (z *RedisZSet) Rem(ctx context.Context, key string, members ...string) (int64, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+	args := make([]interface{}, len(members))
+	for i, v := range members {
+		args[i] = v
+	}
+	cmd := z.conn.rdb().ZRem(ctx, z.doPrefix(key), args...)
+	return cmd.Val(), cmd.Err()
+}
+
+func (z *RedisZSet) Score(ctx context.Context, key string, member string) (float64, bool, error) {
+	cmd := z.conn.rdb().ZScore(ctx, z.doPrefix(key), member)
+	if errors.Is(cmd.Err(), redis.Nil) {
+		return 0, false, nil
+	}
+	if cmd.Err() != nil {
+		return 0, false, cmd.Err()
+	}
+	return cmd.Val(), true, nil
+}
+
+func (z *RedisZSet) Rank(ctx context.Context, key string, member string) (int64, error) {
+	cmd := z.conn.rdb().ZRank(ctx, z.doPrefix(key), member)
+	if errors.Is(cmd.Err(), redis.Nil) {
+		return -1, nil
+	}
+	if cmd.Err() != nil {
+		return -1, cmd.Err()
+	}
+	return cmd.Val(), nil
+}
+
+func (z *RedisZSet) Card(ctx context.Context, key string) (int64, error) {
+	cmd := z.conn.rdb().ZCard(ctx, z.doPrefix(key))
+	return cmd.Val(), cmd.Err()
+}
+
+func (z *RedisZSet) Range(ctx context.Context, key string, start int64, stop int64) ([]ZMember, error) {
+	cmd := z.conn.rdb().ZRangeWithScores(ctx, z.doPrefix(key), start, stop)
+	if cmd.Err() != nil {
+		return nil, cmd.Err()
+	}
+	members := make([]ZMember, 0, len(cmd.Val()))
+	for _, v := range cmd.Val() {
+		member, _ := v.Member.(string)
+		members = append(members, ZMember{Member: member, Score: v.Score})
+	}
+	return members, nil
+}
@@ -0,0 +1,126 @@
+package limitcount
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
+)
+
+// 一个限流key在本节点的接入速度，按时间窗口做指数平滑
+type rate struct {
+	value float64
+	last  time.Time
+	ready bool
+}
+
+// 记录一轮分配接入的人数
+func (r *rate) observe(n int, now time.Time, elapsed time.Duration, window time.Duration) {
+	r.last = now
+	if elapsed <= 0 {
+		return
+	}
+	sample := float64(n) / elapsed.Seconds()
+	if !r.ready {
+		r.value = sample
+		r.ready = true
+		return
+	}
+	alpha := elapsed.Seconds() / window.Seconds()
+	if alpha > 1 {
+		alpha = 1
+	}
+	r.value += alpha * (sample - r.value)
+}
+
+func (s *LimitStatic) getRate(limitkey string) *rate {
+	r, ok := s.rates[limitkey]
+	if !ok {
+		r = &rate{}
+		s.rates[limitkey] = r
+	}
+	return r
+}
+
+// 记录一轮分配的结果，并同步给其他节点
+func (s *LimitStatic) observePromotions(ctx context.Context, limitkey string, n int) {
+	now := time.Now()
+	s.rateLock.Lock()
+	r := s.getRate(limitkey)
+	elapsed := s.waitReadyInterval
+	if !r.last.IsZero() {
+		elapsed = now.Sub(r.last)
+	}
+	r.observe(n, now, elapsed, s.rateWindow)
+	value := r.value
+	s.rateLock.Unlock()
+
+	if err := s.rateClient.Set(ctx, limitkey, s.gateKey, strconv.FormatFloat(value, 'f', 3, 64)); err != nil {
+		s.parant.logger.Error("update throughput failed", "limit_key", limitkey, "gate_key", s.gateKey, "err", err)
+	}
+}
+
+// 队列为空的这一轮不参与计算，避免空闲的时间把速度拉低
+func (s *LimitStatic) skipPromotions(limitkey string) {
+	s.rateLock.Lock()
+	defer s.rateLock.Unlock()
+	if r, ok := s.rates[limitkey]; ok {
+		r.last = time.Now()
+	}
+}
+
+// 本节点的接入速度，决定本节点队列的等待时间
+func (s *LimitStatic) localRate(limitkey string) float64 {
+	s.rateLock.Lock()
+	defer s.rateLock.Unlock()
+	if r, ok := s.rates[limitkey]; ok {
+		return r.value
+	}
+	return 0
+}
+
+// 所有有效节点的接入速度之和
+func (s *LimitStatic) clusterRate(ctx context.Context, limitkey string) float64 {
+	rates, err := s.rateClient.GetAll(ctx, limitkey)
+	if err != nil {
+		s.parant.logger.Error("read throughput failed", "limit_key", limitkey, "gate_key", s.gateKey, "err", err)
+		return s.localRate(limitkey)
+	}
+	ttls, err := s.getAll(ctx)
+	if err != nil {
+		return s.localRate(limitkey)
+	}
+	freshValidValue(ttls, rates, s.ttlInterval)
+	sum := 0.0
+	for _, v := range rates {
+		f, _ := strconv.ParseFloat(v, 64)
+		sum += f
+	}
+	return sum
+}
+
+// 估算等待时间使用的速度，以及整个集群的速度
+// 本节点的队列按本节点的速度估算，全局队列的位置是整个集群的，按整个集群的速度估算
+func (s *LimitStatic) waitRates(ctx context.Context, limitkey string) (float64, float64) {
+	cluster := s.clusterRate(ctx, limitkey)
+	if s.globalClient != nil {
+		return cluster, cluster
+	}
+	return s.localRate(limitkey), cluster
+}
+
+// 排队信息，按照 rate 估算等待时间
+func waitInfo(self int64, total int64, rate float64, cluster float64) *bytecoder.MessageWaitInfo {
+	info := &bytecoder.MessageWaitInfo{
+		Self:       self,
+		Total:      total,
+		EtaSeconds: -1,
+		Throughput: cluster,
+	}
+	if self >= 0 && rate > 0 {
+		info.EtaSeconds = int64(math.Ceil(float64(self+1) / rate))
+	}
+	return info
+}
@@ -0,0 +1,317 @@
+package limitcount
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hnchenkai/mx-wsgo/domain"
+	"github.com/hnchenkai/mx-wsgo/limitcount/redis"
+)
+
+// 队列中的一个链接，放回队列的时候使用
+type waitEntry struct {
+	clientId string
+	lane     int
+	score    float64
+}
+
+// 一个限流key的排队队列
+// 本地模式每个节点一个优先级队列，全局模式所有节点共用redis里面的一个有序集合
+type waitQueue interface {
+	// 排队的总人数，全局模式是整个集群的
+	Size(ctx context.Context) int
+	Add(ctx context.Context, clientId string, lane int)
+	// 从队列中删除，返回删除的链接
+	Remove(ctx context.Context, clientId string) (waitEntry, bool)
+	// 排队的位置和总人数
+	IndexOf(ctx context.Context, clientId string) (int64, int64)
+	// 本节点排队的链接，按照排队顺序，以及它们的位置和总人数
+	Positions(ctx context.Context) ([]string, []int64, int64)
+	// 取出最多n个由本节点负责接入的链接
+	Take(ctx context.Context, n int64) []waitEntry
+	// 接入失败的放回原来的位置
+	Restore(ctx context.Context, entries ...waitEntry)
+}
+
+// 本节点的优先级队列
+type localQueue struct {
+	queue *domain.LaneQueue
+}
+
+func (q *localQueue) Size(ctx context.Context) int {
+	return q.queue.Size()
+}
+
+func (q *localQueue) Add(ctx context.Context, clientId string, lane int) {
+	q.queue.AddLane(clientId, lane)
+}
+
+func (q *localQueue) Remove(ctx context.Context, clientId string) (waitEntry, bool) {
+	lane := q.queue.Lane(clientId)
+	if lane < 0 || !q.queue.Del(clientId) {
+		return waitEntry{}, false
+	}
+	return waitEntry{clientId: clientId, lane: lane}, true
+}
+
+func (q *localQueue) IndexOf(ctx context.Context, clientId string) (int64, int64) {
+	return q.queue.IndexOf(clientId)
+}
+
+func (q *localQueue) Positions(ctx context.Context) ([]string, []int64, int64) {
+	values := q.queue.Values()
+	clients := make([]string, len(values))
+	ranks := make([]int64, len(values))
+	for i, v := range values {
+		clients[i] = v.(string)
+		ranks[i] = int64(i)
+	}
+	return clients, ranks, int64(len(values))
+}
+
+func (q *localQueue) Take(ctx context.Context, n int64) []waitEntry {
+	var entries []waitEntry
+	for i := int64(0); i < n; i++ {
+		clientId, lane := q.queue.ShiftLane()
+		if clientId == nil {
+			// 找不到了
+			break
+		}
+		entries = append(entries, waitEntry{clientId: clientId.(string), lane: lane})
+	}
+	return entries
+}
+
+func (q *localQueue) Restore(ctx context.Context, entries ...waitEntry) {
+	// 倒着放回队首，保持原来的顺序
+	for i := len(entries) - 1; i >= 0; i-- {
+		q.queue.UnshiftLane(entries[i].clientId, entries[i].lane)
+	}
+}
+
+// 每次清理失效节点的链接时检查的数量
+const sweepBatch = 1000
+
+// 全局队列，成员是 gateKey|clientId，分数是进入队列的时间
+// 位置是整个集群的，接入的时候每个节点只负责队首里面自己的链接，通过删除成员保证只接入一次
+type globalQueue struct {
+	limitkey string
+	zset     redis.IZSet
+	static   *LimitStatic
+
+	lock sync.Mutex
+	// 本节点排队的链接，推送位置的时候只查询这些链接的排名，不读取整个队列
+	own map[string]struct{}
+	// 下一次清理从这个位置开始检查
+	sweepLock sync.Mutex
+	cursor    int64
+}
+
+func newGlobalQueue(limitkey string, zset redis.IZSet, static *LimitStatic) *globalQueue {
+	return &globalQueue{
+		limitkey: limitkey,
+		zset:     zset,
+		static:   static,
+		own:      make(map[string]struct{}),
+	}
+}
+
+func (q *globalQueue) remember(clientId string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.own[clientId] = struct{}{}
+}
+
+func (q *globalQueue) forget(clientId string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.own, clientId)
+}
+
+func (q *globalQueue) member(clientId string) string {
+	return q.static.gateKey + "|" + clientId
+}
+
+func (q *globalQueue) logError(msg string, err error) {
+	q.static.parant.logger.Error(msg, "limit_key", q.limitkey, "gate_key", q.static.gateKey, "err", err)
+}
+
+func (q *globalQueue) Size(ctx context.Context) int {
+	size, err := q.zset.Card(ctx, q.limitkey)
+	if err != nil {
+		q.logError("read global queue failed", err)
+	}
+	return int(size)
+}
+
+func (q *globalQueue) Add(ctx context.Context, clientId string, lane int) {
+	// 毫秒时间戳，相同的按照成员排序
+	score := float64(time.Now().UnixMilli())
+	if _, err := q.zset.Add(ctx, q.limitkey, q.member(clientId), score); err != nil {
+		q.logError("add to global queue failed", err)
+		return
+	}
+	q.remember(clientId)
+}
+
+func (q *globalQueue) Remove(ctx context.Context, clientId string) (waitEntry, bool) {
+	member := q.member(clientId)
+	score, ok, err := q.zset.Score(ctx, q.limitkey, member)
+	if err != nil {
+		q.logError("read global queue failed", err)
+		return waitEntry{}, false
+	}
+	if !ok {
+		q.forget(clientId)
+		return waitEntry{}, false
+	}
+	// 删除成功的才算，避免和接入的节点重复处理
+	if n, err := q.zset.Rem(ctx, q.limitkey, member); err != nil || n == 0 {
+		return waitEntry{}, false
+	}
+	q.forget(clientId)
+	return waitEntry{clientId: clientId, score: score}, true
+}
+
+func (q *globalQueue) IndexOf(ctx context.Context, clientId string) (int64, int64) {
+	rank, err := q.zset.Rank(ctx, q.limitkey, q.member(clientId))
+	if err != nil {
+		q.logError("read global queue failed", err)
+		return -1, 0
+	}
+	return rank, int64(q.Size(ctx))
+}
+
+// 只查询本节点链接的排名，redis的访问量和本节点排队的人数有关，和整个队列的长度无关
+func (q *globalQueue) Positions(ctx context.Context) ([]string, []int64, int64) {
+	q.lock.Lock()
+	own := make([]string, 0, len(q.own))
+	for clientId := range q.own {
+		own = append(own, clientId)
+	}
+	q.lock.Unlock()
+	if len(own) == 0 {
+		return nil, nil, 0
+	}
+
+	type position struct {
+		clientId string
+		rank     int64
+	}
+	positions := make([]position, 0, len(own))
+	for _, clientId := range own {
+		rank, err := q.zset.Rank(ctx, q.limitkey, q.member(clientId))
+		if err != nil {
+			q.logError("read global queue failed", err)
+			return nil, nil, 0
+		}
+		if rank < 0 {
+			// 正在被接入或者已经离开
+			continue
+		}
+		positions = append(positions, position{clientId: clientId, rank: rank})
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		return positions[i].rank < positions[j].rank
+	})
+	clients := make([]string, len(positions))
+	ranks := make([]int64, len(positions))
+	for i, v := range positions {
+		clients[i] = v.clientId
+		ranks[i] = v.rank
+	}
+	return clients, ranks, int64(q.Size(ctx))
+}
+
+// 有效的节点，读取失败的时候返回nil
+func (q *globalQueue) liveGates(ctx context.Context) map[string]string {
+	ttls, err := q.static.getAll(ctx)
+	if err != nil {
+		return nil
+	}
+	outttls, _ := freshValidValue(ttls, map[string]string{}, q.static.ttlInterval)
+	q.static.del(ctx, outttls...)
+	return ttls
+}
+
+// 队首的n个里面，本节点的取出来接入，已经失效的节点的顺便清理掉，其他节点的留给它们自己处理
+func (q *globalQueue) Take(ctx context.Context, n int64) []waitEntry {
+	if n <= 0 {
+		return nil
+	}
+	members, err := q.zset.Range(ctx, q.limitkey, 0, n-1)
+	if err != nil {
+		q.logError("read global queue failed", err)
+		return nil
+	}
+	gates := q.liveGates(ctx)
+	var entries []waitEntry
+	var dead []string
+	for _, v := range members {
+		gate, clientId, _ := strings.Cut(v.Member, "|")
+		switch {
+		case gate == q.static.gateKey:
+			if removed, err := q.zset.Rem(ctx, q.limitkey, v.Member); err == nil && removed > 0 {
+				q.forget(clientId)
+				entries = append(entries, waitEntry{clientId: clientId, score: v.Score})
+			}
+		case gates != nil && gates[gate] == "":
+			dead = append(dead, v.Member)
+		}
+	}
+	q.removeDead(ctx, dead)
+	return entries
+}
+
+func (q *globalQueue) Restore(ctx context.Context, entries ...waitEntry) {
+	for _, v := range entries {
+		if _, err := q.zset.Add(ctx, q.limitkey, q.member(v.clientId), v.score); err != nil {
+			q.logError("restore to global queue failed", err)
+			continue
+		}
+		q.remember(v.clientId)
+	}
+}
+
+// 清理队列里面已经失效的节点的链接
+// 每次只检查一批，下一次从后面接着检查，到队尾之后重新开始，队首的在接入的时候也会清理
+func (q *globalQueue) sweep(ctx context.Context) {
+	gates := q.liveGates(ctx)
+	if gates == nil {
+		return
+	}
+	q.sweepLock.Lock()
+	defer q.sweepLock.Unlock()
+	members, err := q.zset.Range(ctx, q.limitkey, q.cursor, q.cursor+sweepBatch-1)
+	if err != nil {
+		q.logError("read global queue failed", err)
+		return
+	}
+	var dead []string
+	for _, v := range members {
+		if gate, _, _ := strings.Cut(v.Member, "|"); gates[gate] == "" {
+			dead = append(dead, v.Member)
+		}
+	}
+	if len(members) < sweepBatch {
+		q.cursor = 0
+	} else {
+		// 删除之后后面的成员会往前移
+		q.cursor += int64(len(members) - len(dead))
+	}
+	q.removeDead(ctx, dead)
+}
+
+func (q *globalQueue) removeDead(ctx context.Context, dead []string) {
+	if len(dead) == 0 {
+		return
+	}
+	if _, err := q.zset.Rem(ctx, q.limitkey, dead...); err != nil {
+		q.logError("remove dead gate entries failed", err)
+		return
+	}
+	q.static.parant.logger.Info("removed wait entries of dead gates", "limit_key", q.limitkey, "gate_key", q.static.gateKey, "count", len(dead))
+}
@@ -0,0 +1,204 @@
+package logger
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// 日志接口，和 log/slog 的 *slog.Logger 兼容，可以直接传入 slog.Default()
+// args 是成对的 key value
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// 日志级别，数值和 slog.Level 一致
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// ParseLevel 解析 debug/info/warn/error，不区分大小写
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "DEBUG":
+		return LevelDebug, nil
+	case "INFO", "":
+		return LevelInfo, nil
+	case "WARN", "WARNING":
+		return LevelWarn, nil
+	case "ERROR":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// 使用标准库 log 输出 key=value 格式的日志
+type stdLogger struct {
+	out   *log.Logger
+	level Level
+	attrs []any
+}
+
+// New 生成一个输出到标准库 log 的日志，out 为空使用 log.Default()
+func New(out *log.Logger, level Level) Logger {
+	if out == nil {
+		out = log.Default()
+	}
+	return &stdLogger{out: out, level: level}
+}
+
+// Default 输出到 log.Default()，Info 级别
+func Default() Logger {
+	return New(nil, LevelInfo)
+}
+
+func (l *stdLogger) Debug(msg string, args ...any) {
+	l.log(LevelDebug, msg, args)
+}
+
+func (l *stdLogger) Info(msg string, args ...any) {
+	l.log(LevelInfo, msg, args)
+}
+
+func (l *stdLogger) Warn(msg string, args ...any) {
+	l.log(LevelWarn, msg, args)
+}
+
+func (l *stdLogger) Error(msg string, args ...any) {
+	l.log(LevelError, msg, args)
+}
+
+func (l *stdLogger) log(level Level, msg string, args []any) {
+	if level < l.level {
+		return
+	}
+	sb := strings.Builder{}
+	sb.WriteString("level=")
+	sb.WriteString(level.String())
+	sb.WriteString(" msg=")
+	sb.WriteString(quote(msg))
+	writeAttrs(&sb, l.attrs)
+	writeAttrs(&sb, args)
+	l.out.Print(sb.String())
+}
+
+func writeAttrs(sb *strings.Builder, args []any) {
+	for i := 0; i < len(args); i += 2 {
+		sb.WriteByte(' ')
+		if i+1 == len(args) {
+			// 落单的值和 slog 一样使用 !BADKEY
+			sb.WriteString("!BADKEY=")
+			sb.WriteString(quote(fmt.Sprint(args[i])))
+			return
+		}
+		sb.WriteString(fmt.Sprint(args[i]))
+		sb.WriteByte('=')
+		sb.WriteString(quote(fmt.Sprint(args[i+1])))
+	}
+}
+
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...any) {}
+func (nopLogger) Info(msg string, args ...any)  {}
+func (nopLogger) Warn(msg string, args ...any)  {}
+func (nopLogger) Error(msg string, args ...any) {}
+
+// Nop 不输出任何日志
+func Nop() Logger {
+	return nopLogger{}
+}
+
+// 按级别过滤
+type levelLogger struct {
+	next  Logger
+	level Level
+}
+
+// WithLevel 过滤掉低于 level 的日志，用于包装 slog 等外部日志
+func WithLevel(l Logger, level Level) Logger {
+	return &levelLogger{next: l, level: level}
+}
+
+func (l *levelLogger) Debug(msg string, args ...any) {
+	if LevelDebug >= l.level {
+		l.next.Debug(msg, args...)
+	}
+}
+
+func (l *levelLogger) Info(msg string, args ...any) {
+	if LevelInfo >= l.level {
+		l.next.Info(msg, args...)
+	}
+}
+
+func (l *levelLogger) Warn(msg string, args ...any) {
+	if LevelWarn >= l.level {
+		l.next.Warn(msg, args...)
+	}
+}
+
+func (l *levelLogger) Error(msg string, args ...any) {
+	if LevelError >= l.level {
+		l.next.Error(msg, args...)
+	}
+}
+
+// 附加固定字段
+type attrLogger struct {
+	next  Logger
+	attrs []any
+}
+
+// With 给每条日志附加固定的字段
+func With(l Logger, args ...any) Logger {
+	if s, ok := l.(*stdLogger); ok {
+		return &stdLogger{out: s.out, level: s.level, attrs: append(append([]any{}, s.attrs...), args...)}
+	}
+	return &attrLogger{next: l, attrs: args}
+}
+
+func (l *attrLogger) Debug(msg string, args ...any) {
+	l.next.Debug(msg, append(append([]any{}, l.attrs...), args...)...)
+}
+
+func (l *attrLogger) Info(msg string, args ...any) {
+	l.next.Info(msg, append(append([]any{}, l.attrs...), args...)...)
+}
+
+func (l *attrLogger) Warn(msg string, args ...any) {
+	l.next.Warn(msg, append(append([]any{}, l.attrs...), args...)...)
+}
+
+func (l *attrLogger) Error(msg string, args ...any) {
+	l.next.Error(msg, append(append([]any{}, l.attrs...), args...)...)
+}
@@ -0,0 +1,36 @@
+package logger_test
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/hnchenkai/mx-wsgo/logger"
+)
+
+func TestStdLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := logger.New(log.New(buf, "", 0), logger.LevelInfo)
+	l.Debug("hidden")
+	logger.With(l, "gate_key", "g1").Warn("redis error", "limit_key", "sale", "err", "dial tcp: refused")
+
+	expect := "level=WARN msg=\"redis error\" gate_key=g1 limit_key=sale err=\"dial tcp: refused\"\n"
+	if buf.String() != expect {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+}
+
+func TestWithLevel(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := logger.WithLevel(logger.New(log.New(buf, "", 0), logger.LevelDebug), logger.LevelError)
+	l.Warn("hidden")
+	l.Error("shown")
+	if buf.String() != "level=ERROR msg=shown\n" {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+
+	level, err := logger.ParseLevel("warn")
+	if err != nil || level != logger.LevelWarn {
+		t.Fatalf("unexpected level %v %v", level, err)
+	}
+}
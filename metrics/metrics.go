@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"time"
+)
+
+// 排队时长的分桶，单位秒
+var WaitBuckets = []float64{.1, .5, 1, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+// 限流池的实时状态
+type PoolStat struct {
+	Limitkey string
+	Ready    int
+	Wait     int
+}
+
+// 服务单元和限流单元的指标，方法都可以在 nil 上调用
+type Metrics struct {
+	*Registry
+
+	Connections      *GaugeVec
+	Messages         *CounterVec
+	Bytes            *CounterVec
+	SendDrops        *CounterVec
+	DispatchDuration *HistogramVec
+	PoolReady        *GaugeVec
+	PoolWait         *GaugeVec
+	Promotions       *CounterVec
+	Rejections       *CounterVec
+	WaitDuration     *HistogramVec
+}
+
+// New 生成一组指标，指标名使用 mxwsgo_ 前缀
+func New() *Metrics {
+	r := NewRegistry()
+	return &Metrics{
+		Registry:         r,
+		Connections:      r.Gauge("mxwsgo_connections", "Live connections by group and admission status.", "group", "status"),
+		Messages:         r.Counter("mxwsgo_messages_total", "Messages received from and sent to clients.", "direction"),
+		Bytes:            r.Counter("mxwsgo_message_bytes_total", "Bytes received from and sent to clients.", "direction"),
+		SendDrops:        r.Counter("mxwsgo_send_dropped_total", "Outbound messages dropped because the send buffer was full.", "reason"),
+		DispatchDuration: r.Histogram("mxwsgo_dispatch_duration_seconds", "Time spent in the dispatcher.", DefBuckets, "cmd"),
+		PoolReady:        r.Gauge("mxwsgo_pool_ready", "Connections in the ready pool by limit key.", "limitkey"),
+		PoolWait:         r.Gauge("mxwsgo_pool_wait", "Connections in the wait pool by limit key.", "limitkey"),
+		Promotions:       r.Counter("mxwsgo_promotions_total", "Connections promoted from the wait queue to ready.", "limitkey"),
+		Rejections:       r.Counter("mxwsgo_rejections_total", "Connections rejected because both pools were full.", "limitkey"),
+		WaitDuration:     r.Histogram("mxwsgo_wait_duration_seconds", "Time spent in the wait queue, by how the wait ended.", WaitBuckets, "limitkey", "outcome"),
+	}
+}
+
+// 收到客户端的消息
+func (m *Metrics) MessageIn(size int) {
+	if m == nil {
+		return
+	}
+	m.Messages.With("in").Inc()
+	m.Bytes.With("in").Add(float64(size))
+}
+
+// 发送给客户端的消息
+func (m *Metrics) MessageOut(size int) {
+	if m == nil {
+		return
+	}
+	m.Messages.With("out").Inc()
+	m.Bytes.With("out").Add(float64(size))
+}
+
+// 发送缓冲区满了丢弃的消息
+func (m *Metrics) SendDropped(reason string) {
+	if m == nil {
+		return
+	}
+	m.SendDrops.With(reason).Inc()
+}
+
+// 分发器的耗时
+func (m *Metrics) ObserveDispatch(cmd string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.DispatchDuration.With(cmd).Observe(d.Seconds())
+}
+
+// 从等待转到接入
+func (m *Metrics) Promoted(limitkey string) {
+	if m == nil {
+		return
+	}
+	m.Promotions.With(limitkey).Inc()
+}
+
+// 两个池都满了被拒绝
+func (m *Metrics) Rejected(limitkey string) {
+	if m == nil {
+		return
+	}
+	m.Rejections.With(limitkey).Inc()
+}
+
+// 排队结束，outcome 为 accept 或者 leave
+func (m *Metrics) ObserveWait(limitkey string, outcome string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.WaitDuration.With(limitkey, outcome).Observe(d.Seconds())
+}
+
+// SetPools 刷新限流池的数量
+func (m *Metrics) SetPools(stats []PoolStat) {
+	if m == nil {
+		return
+	}
+	m.PoolReady.Reset()
+	m.PoolWait.Reset()
+	for _, s := range stats {
+		m.PoolReady.With(s.Limitkey).Set(float64(s.Ready))
+		m.PoolWait.With(s.Limitkey).Set(float64(s.Wait))
+	}
+}
@@ -0,0 +1,387 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 默认的耗时分桶，单位秒
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// 输出的一组指标
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// 指标注册表，按照 Prometheus 文本格式输出
+type Registry struct {
+	lock       sync.Mutex
+	names      map[string]bool
+	collectors []collector
+	hooks      []func()
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) add(name string, c collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// OnCollect 注册输出之前执行的函数，用来刷新需要实时计算的指标
+func (r *Registry) OnCollect(fn func()) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.hooks = append(r.hooks, fn)
+}
+
+// WriteTo 按照 Prometheus 文本格式输出所有指标
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.lock.Lock()
+	hooks := append([]func(){}, r.hooks...)
+	collectors := append([]collector{}, r.collectors...)
+	r.lock.Unlock()
+
+	for _, fn := range hooks {
+		fn()
+	}
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// 指标的描述
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, strings.ReplaceAll(d.help, "\n", " "))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.typ)
+}
+
+// 生成 {a="1",b="2"} 格式的标签
+func (d *desc) labelString(values []string, extra ...string) string {
+	if len(d.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+	sb := strings.Builder{}
+	sb.WriteByte('{')
+	// 先输出自己的标签，再输出额外的标签
+	all := make([]string, 0, len(d.labels)*2+len(extra))
+	for i, name := range d.labels {
+		all = append(all, name, values[i])
+	}
+	all = append(all, extra...)
+	for i := 0; i < len(all); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(all[i])
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabel(all[i+1]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// 按标签值分组的指标
+type vec struct {
+	desc
+	lock   sync.RWMutex
+	values map[string]interface{}
+	keys   map[string][]string
+	create func() interface{}
+}
+
+func newVec(name, help, typ string, labels []string, create func() interface{}) vec {
+	return vec{
+		desc:   desc{name: name, help: help, typ: typ, labels: labels},
+		values: make(map[string]interface{}),
+		keys:   make(map[string][]string),
+		create: create,
+	}
+}
+
+func (v *vec) with(values []string) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.lock.RLock()
+	m, ok := v.values[key]
+	v.lock.RUnlock()
+	if ok {
+		return m
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if m, ok = v.values[key]; !ok {
+		m = v.create()
+		v.values[key] = m
+		v.keys[key] = append([]string{}, values...)
+	}
+	return m
+}
+
+func (v *vec) reset() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.values = make(map[string]interface{})
+	v.keys = make(map[string][]string)
+}
+
+// 按标签排序遍历，输出稳定
+func (v *vec) each(fn func(values []string, m interface{})) {
+	v.lock.RLock()
+	keys := make([]string, 0, len(v.values))
+	for k := range v.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	items := make([]interface{}, len(keys))
+	labels := make([][]string, len(keys))
+	for i, k := range keys {
+		items[i] = v.values[k]
+		labels[i] = v.keys[k]
+	}
+	v.lock.RUnlock()
+
+	for i := range items {
+		fn(labels[i], items[i])
+	}
+}
+
+// 浮点数的原子操作
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		val := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&f.bits, old, val) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) set(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+func (f *atomicFloat) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+// 只增不减的计数
+type Counter struct {
+	value atomicFloat
+}
+
+func (c *Counter) Inc() {
+	c.value.add(1)
+}
+
+// Add 增加计数，负数会被忽略
+func (c *Counter) Add(v float64) {
+	if v > 0 {
+		c.value.add(v)
+	}
+}
+
+func (c *Counter) Value() float64 {
+	return c.value.get()
+}
+
+type CounterVec struct {
+	vec
+}
+
+// Counter 注册一个计数指标
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, "counter", labels, func() interface{} { return &Counter{} })}
+	r.add(name, c)
+	return c
+}
+
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	return c.with(labelValues).(*Counter)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	c.each(func(values []string, m interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(values), formatFloat(m.(*Counter).Value()))
+	})
+}
+
+// 可增可减的数值
+type Gauge struct {
+	value atomicFloat
+}
+
+func (g *Gauge) Set(v float64) {
+	g.value.set(v)
+}
+
+func (g *Gauge) Add(v float64) {
+	g.value.add(v)
+}
+
+func (g *Gauge) Inc() {
+	g.value.add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.value.add(-1)
+}
+
+func (g *Gauge) Value() float64 {
+	return g.value.get()
+}
+
+type GaugeVec struct {
+	vec
+}
+
+// Gauge 注册一个数值指标
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, "gauge", labels, func() interface{} { return &Gauge{} })}
+	r.add(name, g)
+	return g
+}
+
+func (g *GaugeVec) With(labelValues ...string) *Gauge {
+	return g.with(labelValues).(*Gauge)
+}
+
+// Reset 清空所有的标签，用于实时计算的指标
+func (g *GaugeVec) Reset() {
+	g.reset()
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.writeHeader(w)
+	g.each(func(values []string, m interface{}) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelString(values), formatFloat(m.(*Gauge).Value()))
+	})
+}
+
+// 分桶统计
+type Histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     atomicFloat
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.counts) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	atomic.AddUint64(&h.count, 1)
+	h.sum.add(v)
+}
+
+// Count 观察的次数
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// Histogram 注册一个分桶统计指标，buckets 为空使用 DefBuckets
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{buckets: buckets}
+	h.vec = newVec(name, help, "histogram", labels, func() interface{} {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})
+	r.add(name, h)
+	return h
+}
+
+func (h *HistogramVec) With(labelValues ...string) *Histogram {
+	return h.with(labelValues).(*Histogram)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.each(func(values []string, m interface{}) {
+		hist := m.(*Histogram)
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += atomic.LoadUint64(&hist.counts[i])
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(values, "le", formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(values, "le", "+Inf"), hist.Count())
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(values), formatFloat(hist.sum.get()))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(values), hist.Count())
+	})
+}
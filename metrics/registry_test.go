@@ -0,0 +1,57 @@
+package metrics_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hnchenkai/mx-wsgo/metrics"
+)
+
+func TestPrometheusText(t *testing.T) {
+	r := metrics.NewRegistry()
+	counter := r.Counter("test_total", "A counter.", "kind")
+	gauge := r.Gauge("test_gauge", "A gauge.")
+	hist := r.Histogram("test_seconds", "A histogram.", []float64{0.1, 1}, "cmd")
+
+	counter.With(`a"b`).Add(2)
+	counter.With("c").Inc()
+	counter.With("c").Add(-1)
+	gauge.With().Set(3.5)
+	hist.With("x").Observe(0.05)
+	hist.With("x").Observe(0.5)
+	hist.With("x").Observe(5)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %q", rec.Header().Get("Content-Type"))
+	}
+	expect := `# HELP test_total A counter.
+# TYPE test_total counter
+test_total{kind="a\"b"} 2
+test_total{kind="c"} 1
+# HELP test_gauge A gauge.
+# TYPE test_gauge gauge
+test_gauge 3.5
+# HELP test_seconds A histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{cmd="x",le="0.1"} 1
+test_seconds_bucket{cmd="x",le="1"} 2
+test_seconds_bucket{cmd="x",le="+Inf"} 3
+test_seconds_sum{cmd="x"} 5.55
+test_seconds_count{cmd="x"} 3
+`
+	if rec.Body.String() != expect {
+		t.Fatalf("unexpected output:\n%s", rec.Body.String())
+	}
+}
+
+func TestMetricsNil(t *testing.T) {
+	var m *metrics.Metrics
+	m.MessageIn(1)
+	m.SendDropped("timeout")
+	m.ObserveWait("k", "accept", time.Second)
+	m.SetPools(nil)
+}
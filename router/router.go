@@ -0,0 +1,213 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+// 路由处理函数，返回的结果会自动通过 SendResponse 发送给客户端
+// 返回错误的时候通过 SendError 发送，错误码参考 Error
+type HandlerFunc func(ctx context.Context, msg *wsmessage.WSMessage) (code int32, body []byte, header map[string]string, err error)
+
+// 事件处理函数，和 serverunit.Dispather 一致
+type EventFunc func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage)
+
+type route struct {
+	method   string
+	pattern  string
+	segments []string
+	handler  HandlerFunc
+}
+
+// 类似 http.ServeMux 的路由
+// Messagev1 按 method+route 匹配，Messagev2 按 cmd 匹配
+type Router struct {
+	routes []*route
+	cmds   map[int32]HandlerFunc
+	// 非 CmdMessage 的事件交给它处理
+	event EventFunc
+	// 找不到路由的时候的处理，默认返回404
+	notFound HandlerFunc
+}
+
+func New() *Router {
+	return &Router{
+		cmds: make(map[int32]HandlerFunc),
+	}
+}
+
+/**
+ * @brief:  注册路由
+ * @param:  method 请求类型，为空或者*表示所有类型
+ * @param:  pattern 路由，支持 /users/{id} 形式的参数和 /static/* 形式的通配，通配部分的参数名为 *
+ * @param:  handler 处理函数
+ */
+func (r *Router) Handle(method string, pattern string, handler HandlerFunc) {
+	if method == "*" {
+		method = ""
+	}
+	r.routes = append(r.routes, &route{
+		method:   strings.ToUpper(method),
+		pattern:  pattern,
+		segments: splitPath(pattern),
+		handler:  handler,
+	})
+}
+
+// 注册 Messagev2 的cmd
+func (r *Router) HandleCmd(cmd int32, handler HandlerFunc) {
+	r.cmds[cmd] = handler
+}
+
+// 设置 accept close wait 等事件的处理函数
+func (r *Router) OnEvent(fn EventFunc) {
+	r.event = fn
+}
+
+// 设置找不到路由的时候的处理函数
+func (r *Router) NotFound(handler HandlerFunc) {
+	r.notFound = handler
+}
+
+// Dispatch 可以直接作为 serverunit.Dispather 使用
+func (r *Router) Dispatch(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
+	if cmd != wsmessage.CmdMessage {
+		if r.event != nil {
+			r.event(cmd, msg)
+		}
+		return
+	}
+	r.Serve(msg.Context(), msg)
+}
+
+// Serve 匹配路由并把结果发送给客户端
+func (r *Router) Serve(ctx context.Context, msg *wsmessage.WSMessage) {
+	handler, params := r.Match(msg)
+	if handler == nil {
+		handler = r.notFound
+	}
+	if handler == nil {
+		msg.SendError(http.StatusNotFound, "not found", nil)
+		return
+	}
+	if len(params) > 0 {
+		ctx = context.WithValue(ctx, paramsKey{}, params)
+	}
+
+	code, body, header, err := handler(ctx, msg)
+	Respond(msg, code, body, header, err)
+}
+
+// Respond 把处理函数的返回值应答给客户端
+func Respond(msg *wsmessage.WSMessage, code int32, body []byte, header map[string]string, err error) bool {
+	if err != nil {
+		return msg.SendError(ErrorCode(err), err.Error(), header)
+	}
+	if code == 0 {
+		code = http.StatusOK
+	}
+	return msg.SendResponse(code, body, header)
+}
+
+// Match 查找消息对应的处理函数和路由参数
+func (r *Router) Match(msg *wsmessage.WSMessage) (HandlerFunc, map[string]string) {
+	if msg.Version == int(bytecoder.Version_VERSION_2) {
+		if handler, ok := r.cmds[int32(msg.Cmd)]; ok {
+			return handler, nil
+		}
+	}
+
+	path := msg.Route
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	segments := splitPath(path)
+	method := strings.ToUpper(msg.Method)
+
+	// 静态路由优先
+	for _, v := range r.routes {
+		if v.pattern == path && (v.method == "" || v.method == method) {
+			return v.handler, nil
+		}
+	}
+	for _, v := range r.routes {
+		if v.method != "" && v.method != method {
+			continue
+		}
+		if params, ok := v.match(segments); ok {
+			return v.handler, params
+		}
+	}
+	return nil, nil
+}
+
+func (rt *route) match(segments []string) (map[string]string, bool) {
+	params := map[string]string{}
+	for i, seg := range rt.segments {
+		if seg == "*" {
+			params["*"] = strings.Join(segments[i:], "/")
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			params[seg[1:len(seg)-1]] = segments[i]
+			continue
+		}
+		if seg != segments[i] {
+			return nil, false
+		}
+	}
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+type paramsKey struct{}
+
+// Param 获取路由参数
+func Param(ctx context.Context, name string) string {
+	params, _ := ctx.Value(paramsKey{}).(map[string]string)
+	return params[name]
+}
+
+// 带状态码的错误，处理函数返回它的时候使用对应的状态码
+type Error struct {
+	Code    int32
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func NewError(code int32, message string) error {
+	return &Error{Code: code, Message: message}
+}
+
+// ErrorCode 获取错误对应的状态码，超时504，取消408，默认500
+func ErrorCode(err error) int32 {
+	var routeErr *Error
+	if errors.As(err, &routeErr) && routeErr.Code > 0 {
+		return routeErr.Code
+	}
+	if code, ok := contextErrorCode(err); ok {
+		return code
+	}
+	return http.StatusInternalServerError
+}
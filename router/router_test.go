@@ -0,0 +1,135 @@
+package router_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
+	"github.com/hnchenkai/mx-wsgo/router"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+	"google.golang.org/protobuf/proto"
+)
+
+// 模拟一个链接，返回收到的应答
+func newMessage(t *testing.T, frame bytecoder.StreamCoder) (*wsmessage.WSMessage, func() *bytecoder.Messagev0) {
+	var sent []byte
+	msg := &wsmessage.WSMessage{
+		ClientId:  "1",
+		OrgHeader: http.Header{},
+		Send: func(message []byte) bool {
+			sent = message
+			return true
+		},
+	}
+	frame.Gzip()
+	frame.EncodeWS()
+	if err := msg.FromPb(frame); err != nil {
+		t.Fatal(err)
+	}
+	return msg, func() *bytecoder.Messagev0 {
+		coder := bytecoder.StreamCoder(sent)
+		coder.DecodeWS()
+		if err := coder.UnGzip(); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := coder.UnmarshalV0()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r := router.New()
+	r.Handle(http.MethodGet, "/users/{id}", func(ctx context.Context, msg *wsmessage.WSMessage) (int32, []byte, map[string]string, error) {
+		return 0, []byte("user " + router.Param(ctx, "id")), nil, nil
+	})
+	r.Handle(http.MethodGet, "/users/me", func(ctx context.Context, msg *wsmessage.WSMessage) (int32, []byte, map[string]string, error) {
+		return http.StatusOK, []byte("me"), nil, nil
+	})
+	r.Handle("", "/fail", func(ctx context.Context, msg *wsmessage.WSMessage) (int32, []byte, map[string]string, error) {
+		return 0, nil, nil, router.NewError(http.StatusForbidden, "forbidden")
+	})
+	r.HandleCmd(7, func(ctx context.Context, msg *wsmessage.WSMessage) (int32, []byte, map[string]string, error) {
+		return 0, msg.Message, nil, nil
+	})
+
+	cases := []struct {
+		frame bytecoder.StreamCoder
+		code  int32
+		body  string
+	}{
+		{bytecoder.MarshalV1(1, "GET", "/users/42", nil, nil, false), http.StatusOK, "user 42"},
+		{bytecoder.MarshalV1(2, "GET", "/users/me?x=1", nil, nil, false), http.StatusOK, "me"},
+		{bytecoder.MarshalV1(3, "POST", "/users/42", nil, nil, false), http.StatusNotFound, ""},
+		{bytecoder.MarshalV1(4, "POST", "/fail", nil, nil, false), http.StatusForbidden, ""},
+		{bytecoder.MarshalV2(5, 7, []byte("echo"), nil), http.StatusOK, "echo"},
+		{bytecoder.MarshalV2(6, 8, nil, nil), http.StatusNotFound, ""},
+	}
+	for _, c := range cases {
+		msg, resp := newMessage(t, c.frame)
+		r.Dispatch(wsmessage.CmdMessage, msg)
+		got := resp()
+		if got.GetRequestId() != msg.ReqId || got.GetCode() != c.code {
+			t.Fatalf("request %d: unexpected response %v", msg.ReqId, got)
+		}
+		if c.body != "" && string(got.GetMessage()) != c.body {
+			t.Fatalf("request %d: unexpected body %s", msg.ReqId, got.GetMessage())
+		}
+	}
+}
+
+func TestTypedHandler(t *testing.T) {
+	r := router.New()
+	router.Handle(r, "/wait", func(ctx context.Context, msg *wsmessage.WSMessage, req *bytecoder.MessageWaitInfo) (*bytecoder.MessageWaitInfo, error) {
+		if req.GetTotal() < 0 {
+			return nil, router.NewError(http.StatusUnprocessableEntity, "negative total")
+		}
+		return &bytecoder.MessageWaitInfo{Self: req.GetSelf() + 1, Total: req.GetTotal()}, nil
+	})
+	router.HandleCmd(r, 9, func(ctx context.Context, msg *wsmessage.WSMessage, req *bytecoder.MessageWaitInfo) (*bytecoder.MessageWaitInfo, error) {
+		ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+		defer cancel()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	body, _ := proto.Marshal(&bytecoder.MessageWaitInfo{Self: 1, Total: 10})
+	msg, resp := newMessage(t, bytecoder.MarshalV1(1, "POST", "/wait", body, nil, false))
+	r.Dispatch(wsmessage.CmdMessage, msg)
+	got := resp()
+	info := &bytecoder.MessageWaitInfo{}
+	if err := proto.Unmarshal(got.GetMessage(), info); err != nil {
+		t.Fatal(err)
+	}
+	if got.GetCode() != http.StatusOK || info.GetSelf() != 2 || info.GetTotal() != 10 {
+		t.Fatalf("unexpected response %v %v", got, info)
+	}
+
+	body, _ = proto.Marshal(&bytecoder.MessageWaitInfo{Total: -1})
+	msg, resp = newMessage(t, bytecoder.MarshalV1(2, "POST", "/wait", body, nil, false))
+	r.Dispatch(wsmessage.CmdMessage, msg)
+	if code := resp().GetCode(); code != http.StatusUnprocessableEntity {
+		t.Fatalf("unexpected code %d", code)
+	}
+
+	msg, resp = newMessage(t, bytecoder.MarshalV1(3, "POST", "/wait", []byte{0xff}, nil, false))
+	r.Dispatch(wsmessage.CmdMessage, msg)
+	if code := resp().GetCode(); code != http.StatusBadRequest {
+		t.Fatalf("unexpected code %d", code)
+	}
+
+	msg, resp = newMessage(t, bytecoder.MarshalV2(4, 9, nil, nil))
+	r.Dispatch(wsmessage.CmdMessage, msg)
+	if code := resp().GetCode(); code != http.StatusGatewayTimeout {
+		t.Fatalf("unexpected code %d", code)
+	}
+
+	if router.ErrorCode(errors.New("boom")) != http.StatusInternalServerError {
+		t.Fatal("unexpected default code")
+	}
+}
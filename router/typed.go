@@ -0,0 +1,70 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+	"google.golang.org/protobuf/proto"
+)
+
+// 请求实现了这个接口的时候，解码之后会先校验，失败返回400
+type Validator interface {
+	Validate() error
+}
+
+// 强类型的处理函数，请求和应答都是protobuf消息
+type TypedHandlerFunc[Req, Resp proto.Message] func(ctx context.Context, msg *wsmessage.WSMessage, req Req) (Resp, error)
+
+// Typed 把强类型的处理函数转换成普通的处理函数
+// 请求体按 protobuf 解码，应答按 protobuf 编码后放在 Messagev0 中返回
+func Typed[Req, Resp proto.Message](fn TypedHandlerFunc[Req, Resp]) HandlerFunc {
+	return func(ctx context.Context, msg *wsmessage.WSMessage) (int32, []byte, map[string]string, error) {
+		var zero Req
+		req := zero.ProtoReflect().New().Interface().(Req)
+		if err := proto.Unmarshal(msg.Message, req); err != nil {
+			return 0, nil, nil, NewError(http.StatusBadRequest, "invalid request body")
+		}
+		if v, ok := any(req).(Validator); ok {
+			if err := v.Validate(); err != nil {
+				return 0, nil, nil, NewError(http.StatusBadRequest, err.Error())
+			}
+		}
+
+		resp, err := fn(ctx, msg, req)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if any(resp) == nil || !resp.ProtoReflect().IsValid() {
+			// 返回nil的时候应答一个空的消息体
+			return http.StatusOK, nil, nil, nil
+		}
+		body, err := proto.Marshal(resp)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		return http.StatusOK, body, nil, nil
+	}
+}
+
+// Handle 注册强类型的路由，匹配所有请求类型
+func Handle[Req, Resp proto.Message](r *Router, route string, fn TypedHandlerFunc[Req, Resp]) {
+	r.Handle("", route, Typed(fn))
+}
+
+// HandleCmd 注册强类型的 Messagev2 cmd
+func HandleCmd[Req, Resp proto.Message](r *Router, cmd int32, fn TypedHandlerFunc[Req, Resp]) {
+	r.HandleCmd(cmd, Typed(fn))
+}
+
+// 超时和取消的错误码
+func contextErrorCode(err error) (int32, bool) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, true
+	case errors.Is(err, context.Canceled):
+		return http.StatusRequestTimeout, true
+	}
+	return 0, false
+}
@@ -3,14 +3,41 @@ package mxwsgo
 import (
 	"context"
 	"net/http"
+	"time"
 
+	"github.com/hnchenkai/mx-wsgo/auth"
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
 	"github.com/hnchenkai/mx-wsgo/limitcount"
+	"github.com/hnchenkai/mx-wsgo/logger"
+	"github.com/hnchenkai/mx-wsgo/metrics"
 	"github.com/hnchenkai/mx-wsgo/serverunit"
+	"github.com/hnchenkai/mx-wsgo/tracing"
 	"github.com/hnchenkai/mx-wsgo/wsmessage"
 )
 
 type LimitOption = limitcount.LimitOption
 
+type Option = serverunit.Option
+
+type Logger = logger.Logger
+
+type SessionOptions = serverunit.SessionOptions
+
+type ClientOptions = serverunit.ClientOptions
+
+type ClientOption = serverunit.ClientOption
+
+type SendBufferOptions = serverunit.SendBufferOptions
+
+type Middleware = serverunit.Middleware
+
+const (
+	SlowConsumerBlock      = serverunit.SlowConsumerBlock
+	SlowConsumerDropNewest = serverunit.SlowConsumerDropNewest
+	SlowConsumerDropOldest = serverunit.SlowConsumerDropOldest
+	SlowConsumerDisconnect = serverunit.SlowConsumerDisconnect
+)
+
 type IServerUnit interface {
 	// 添加链接信息
 	AddHeader(clientId string, key string, value string) bool
@@ -31,16 +58,24 @@ type IServerUnit interface {
 	ServeHTTP(w http.ResponseWriter, r *http.Request)
 	// 获取等待信息
 	WaitUnitInfo(ctx context.Context, limitkey string, clientId string) (int64, int64)
+	// 获取等待信息，带上预计的等待时间和接入速度
+	WaitInfo(ctx context.Context, limitkey string, clientId string) *bytecoder.MessageWaitInfo
 }
 
 /**
  * 创建一个新的服务单元
  * @param  {[type]} dispatcher serverunit.Dispather 服务分发器
  * @param  {[type]} limitOption *limitcount.LimitOption 限制选项，支持本地限制链接和redis分布式限制
+ * @param  {[type]} opts        ...Option 其他可选配置
  * @return {[type]}             IServerUnit 服务器单元
  */
-func NewServerUnit(dispatcher serverunit.Dispather, limitOption *LimitOption) IServerUnit {
-	return serverunit.NewServerUnit(dispatcher, limitOption)
+func NewServerUnit(dispatcher serverunit.Dispather, limitOption *LimitOption, opts ...Option) IServerUnit {
+	return serverunit.NewServerUnit(dispatcher, limitOption, opts...)
+}
+
+// 设置升级ws之前的鉴权器
+func WithAuthenticator(authenticator auth.Authenticator) Option {
+	return serverunit.WithAuthenticator(authenticator)
 }
 
 // 设置分组信息
@@ -54,3 +89,103 @@ func AddHeader(header http.Header, k string, group string) http.Header {
 	header.Add(wsmessage.PrefixProxyHeader+k, group)
 	return header
 }
+
+// 在升级ws之前判断限流状态，被拒绝的链接直接返回429
+func WithPreUpgradeAdmission(retryAfter time.Duration) Option {
+	return serverunit.WithPreUpgradeAdmission(retryAfter)
+}
+
+// 设置所有链接的配置
+func WithClientOptions(opts ...ClientOption) Option {
+	return serverunit.WithClientOptions(opts...)
+}
+
+// 设置某个分组的链接配置，在全局配置的基础上覆盖
+func WithGroupClientOptions(group string, opts ...ClientOption) Option {
+	return serverunit.WithGroupClientOptions(group, opts...)
+}
+
+// 写超时时间
+func WithWriteWait(d time.Duration) ClientOption {
+	return serverunit.WithWriteWait(d)
+}
+
+// 等待pong的超时时间
+func WithPongWait(d time.Duration) ClientOption {
+	return serverunit.WithPongWait(d)
+}
+
+// 发送ping的间隔
+func WithPingPeriod(d time.Duration) ClientOption {
+	return serverunit.WithPingPeriod(d)
+}
+
+// 客户端单条消息的最大字节数
+func WithMaxMessageSize(size int64) ClientOption {
+	return serverunit.WithMaxMessageSize(size)
+}
+
+// 发送消息的类型 TextMessage = 1 BinaryMessage = 2
+func WithByteType(byteType int) ClientOption {
+	return serverunit.WithByteType(byteType)
+}
+
+// 设置发送缓冲区大小和缓冲区满了之后的处理策略
+func WithSendBuffer(opt SendBufferOptions) Option {
+	return serverunit.WithSendBuffer(opt)
+}
+
+// 开启有序分发，同一个链接的消息按顺序处理，所有链接共享一个有界的协程池
+func WithOrderedDispatch(workers int, queueSize int) Option {
+	return serverunit.WithOrderedDispatch(workers, queueSize)
+}
+
+// 有序分发模式下每个链接排队的消息上限，超过之后暂停读取这个链接的消息
+func WithDispatchClientLimit(n int) Option {
+	return serverunit.WithDispatchClientLimit(n)
+}
+
+// 有序分发模式下，这些路由仍然每条消息一个协程并发处理
+func WithConcurrentRoutes(routes ...string) Option {
+	return serverunit.WithConcurrentRoutes(routes...)
+}
+
+// 添加分发器的中间件，按照添加的顺序执行，第一个在最外层
+func WithMiddleware(mws ...Middleware) Option {
+	return serverunit.WithMiddleware(mws...)
+}
+
+// 设置客户端要求返回应答头时的过滤规则
+func WithResponseHeaderPolicy(policy wsmessage.HeaderPolicy) Option {
+	return serverunit.WithResponseHeaderPolicy(policy)
+}
+
+// 统计链接、消息、限流池的指标，metrics.Metrics 本身就是输出 Prometheus 格式的 http.Handler
+func WithMetrics(m *metrics.Metrics) Option {
+	return serverunit.WithMetrics(m)
+}
+
+// 设置日志，兼容 *slog.Logger，级别可以使用 logger.WithLevel 控制
+func WithLogger(l Logger) Option {
+	return serverunit.WithLogger(l)
+}
+
+// 设置追踪的钩子，在收到帧、解码、分发开始和结束、发送应答的时候调用
+func WithTracer(t tracing.Tracer) Option {
+	return serverunit.WithTracer(t)
+}
+
+// 开启会话，断开的链接在宽限期内带着token重连可以恢复原来的链接id、排队位置和接入名额
+func WithSessions(opt SessionOptions) Option {
+	return serverunit.WithSessions(opt)
+}
+
+// 设置排队的优先级，根据链接的header计算，对应 LimitOption.LaneWeights 的下标
+func WithPriority(fn func(header http.Header) int) Option {
+	return serverunit.WithPriority(fn)
+}
+
+// 从header中读取数字作为优先级
+func HeaderPriority(key string) func(header http.Header) int {
+	return serverunit.HeaderPriority(key)
+}
@@ -88,7 +88,6 @@ func TestWait(t *testing.T) {
 		},
 		WaitLimitFunc: func(key string) int {
 			panic("not implement")
-			return 1
 		},
 	})
 	go unit.Run()
@@ -0,0 +1,110 @@
+package serverunit_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
+	"github.com/hnchenkai/mx-wsgo/limitcount"
+	"github.com/hnchenkai/mx-wsgo/serverunit"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+func TestPreUpgradeAdmission(t *testing.T) {
+	unit := serverunit.NewServerUnit(func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {}, &limitcount.LimitOption{
+		ReadyLimitFunc: func(limitkey string) int {
+			return 1
+		},
+		WaitLimitFunc: func(limitkey string) int {
+			return 0
+		},
+	}, serverunit.WithPreUpgradeAdmission(0))
+	go unit.Run()
+
+	svr := httptest.NewServer(unit)
+	defer svr.Close()
+	url := "ws" + strings.TrimPrefix(svr.URL, "http")
+	header := http.Header{}
+	header.Set(wsmessage.WsGroupHeader, "test")
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err == nil || resp == nil {
+		t.Fatalf("expected reject, got %v", err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") != "5" {
+		t.Fatalf("unexpected Retry-After %q", resp.Header.Get("Retry-After"))
+	}
+}
+
+// 升级之前判断为排队的链接，注册之后放入等待队列，名额空出来之后被接入
+func TestPreUpgradeWaitPromoted(t *testing.T) {
+	unit := serverunit.NewServerUnit(func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {}, &limitcount.LimitOption{
+		ReadyLimitFunc: func(limitkey string) int {
+			return 1
+		},
+		WaitLimitFunc: func(limitkey string) int {
+			return 1
+		},
+		PositionInterval: -1,
+	}, serverunit.WithPreUpgradeAdmission(0))
+	go unit.Run()
+	defer unit.Close()
+
+	svr := httptest.NewServer(unit)
+	defer svr.Close()
+	url := "ws" + strings.TrimPrefix(svr.URL, "http")
+	header := http.Header{}
+	header.Set(wsmessage.WsGroupHeader, "test")
+
+	first, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if cmd := readCmd(t, second, time.Second); cmd != bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_WAIT {
+		t.Fatalf("unexpected cmd %v", cmd)
+	}
+	if got := strings.Join(unit.WaitQueues()["test"], ","); got == "" {
+		t.Fatal("waiting client not queued")
+	}
+
+	first.Close()
+	if cmd := readCmd(t, second, 10*time.Second); cmd != bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_ACCEPT {
+		t.Fatalf("unexpected cmd %v", cmd)
+	}
+}
+
+// 读取下一个命令
+func readCmd(t *testing.T, conn *websocket.Conn, timeout time.Duration) bytecoder.MsgLocalCmd {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	coder := bytecoder.StreamCoder(bytes.Split(data, []byte{'\n'})[0])
+	coder.DecodeWS()
+	coder.UnGzip()
+	msg, err := coder.UnmarshalCmd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.GetCmd()
+}
@@ -3,36 +3,92 @@ package serverunit
 import (
 	"bytes"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/hnchenkai/mx-wsgo/logger"
+	"github.com/hnchenkai/mx-wsgo/metrics"
 	"github.com/hnchenkai/mx-wsgo/wsmessage"
 )
 
 type ClientOptions struct {
 	// Time allowed to write a message to the peer.
-	writeWait time.Duration
+	WriteWait time.Duration
 
 	// Time allowed to read the next pong message from the peer.
-	pongWait time.Duration
+	PongWait time.Duration
 
 	// Send pings to peer with this period. Must be less than pongWait.
-	pingPeriod time.Duration
+	PingPeriod time.Duration
 
 	// Maximum message size allowed from peer.
-	maxMessageSize int64
+	MaxMessageSize int64
 
 	// TextMessage = 1 BinaryMessage = 2
-	byteType int
+	ByteType int
 }
 
-func defaultOptions() *ClientOptions {
+// 修改链接配置的方法
+type ClientOption func(*ClientOptions)
+
+// 默认的链接配置
+func DefaultClientOptions() *ClientOptions {
 	return &ClientOptions{
-		writeWait:      10 * time.Second,
-		pongWait:       60 * time.Second,
-		pingPeriod:     (60 * time.Second * 9) / 10,
-		maxMessageSize: 3512,
-		byteType:       1,
+		WriteWait:      10 * time.Second,
+		PongWait:       60 * time.Second,
+		PingPeriod:     (60 * time.Second * 9) / 10,
+		MaxMessageSize: 3512,
+		ByteType:       websocket.BinaryMessage,
+	}
+}
+
+// 写超时时间
+func WithWriteWait(d time.Duration) ClientOption {
+	return func(o *ClientOptions) {
+		o.WriteWait = d
+	}
+}
+
+// 等待pong的超时时间，ping的间隔不小于它的时候会自动调整为它的9/10
+func WithPongWait(d time.Duration) ClientOption {
+	return func(o *ClientOptions) {
+		o.PongWait = d
+	}
+}
+
+// 发送ping的间隔
+func WithPingPeriod(d time.Duration) ClientOption {
+	return func(o *ClientOptions) {
+		o.PingPeriod = d
+	}
+}
+
+// 客户端单条消息的最大字节数
+func WithMaxMessageSize(size int64) ClientOption {
+	return func(o *ClientOptions) {
+		o.MaxMessageSize = size
+	}
+}
+
+// 发送消息的类型 TextMessage = 1 BinaryMessage = 2
+func WithByteType(byteType int) ClientOption {
+	return func(o *ClientOptions) {
+		o.ByteType = byteType
+	}
+}
+
+// 修正不合法的配置
+func (o *ClientOptions) normalize() {
+	if o.PongWait <= 0 {
+		o.PongWait = 60 * time.Second
+	}
+	if o.PingPeriod <= 0 || o.PingPeriod >= o.PongWait {
+		o.PingPeriod = (o.PongWait * 9) / 10
+	}
+	if o.WriteWait <= 0 {
+		o.WriteWait = 10 * time.Second
 	}
 }
 
@@ -55,6 +111,8 @@ type IHubServer interface {
 	Unregister(string)
 	// 接收到消息后，消息分派给具体的用户处理协程
 	Dispatch(string, string, wsmessage.Cmd, []byte, http.Header)
+	// 收到客户端的原始消息
+	Receive(*Connection, []byte)
 }
 
 // Client is a middleman between the websocket connection and the hub.
@@ -68,12 +126,156 @@ type Connection struct {
 	// Buffered channel of outbound messages.
 	send chan []byte
 
+	// 链接被注销后关闭，send 不再关闭，避免并发发送的时候panic
+	done      chan struct{}
+	closeOnce sync.Once
+	// 服务端主动断开的原因，会放在close帧里面
+	closeReason string
+
 	options *ClientOptions
 
 	host string
 
+	// 建立链接的时间
+	connectedAt time.Time
+
 	// 连接的时候记录的head信息，主要是useragent等
-	header http.Header
+	header     http.Header
+	headerLock sync.RWMutex
+
+	// 升级之前已经确定的限流状态，为空表示注册之后再判断
+	admission wsmessage.LimitStatus
+
+	metrics *metrics.Metrics
+	logger  logger.Logger
+
+	// 会话模式下断开之后等待重连的标记和计时器
+	detached int32
+	grace    *time.Timer
+	// 断线重连时要恢复的旧链接，以及客户端已经收到的最后一条消息的序号
+	resumeFrom *Connection
+	resumeSeq  int64
+	// 会话的发送序号和重放缓冲区，没有开启会话的时候为空
+	outbox *outbox
+}
+
+// Header 获取header的拷贝
+func (c *Connection) Header() http.Header {
+	c.headerLock.RLock()
+	defer c.headerLock.RUnlock()
+	return c.header.Clone()
+}
+
+func (c *Connection) GetHeader(key string) string {
+	c.headerLock.RLock()
+	defer c.headerLock.RUnlock()
+	return c.header.Get(key)
+}
+
+func (c *Connection) AddHeader(key string, value string) {
+	c.headerLock.Lock()
+	defer c.headerLock.Unlock()
+	c.header.Add(key, value)
+}
+
+func (c *Connection) SetHeader(key string, value string) {
+	c.headerLock.Lock()
+	defer c.headerLock.Unlock()
+	c.header.Set(key, value)
+}
+
+func (c *Connection) DelHeader(key string) {
+	c.headerLock.Lock()
+	defer c.headerLock.Unlock()
+	c.header.Del(key)
+}
+
+// 不阻塞的放入发送队列，队列满了或者链接已经关闭的时候返回false
+func (c *Connection) tryPush(message []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+// 阻塞的放入发送队列，timeout 为0表示一直等待
+func (c *Connection) pushTimeout(message []byte, timeout time.Duration) bool {
+	var expire <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expire = timer.C
+	}
+
+	select {
+	case c.send <- message:
+		return true
+	case <-c.done:
+		return false
+	case <-expire:
+		return false
+	}
+}
+
+// 丢弃队列中最早的一条消息
+func (c *Connection) dropOldest() bool {
+	select {
+	case <-c.send:
+		return true
+	default:
+		return false
+	}
+}
+
+// 链接是否已经关闭
+func (c *Connection) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// 是否是断开后等待重连的会话
+func (c *Connection) isDetached() bool {
+	return atomic.LoadInt32(&c.detached) == 1
+}
+
+// 关闭链接，writePump 会把剩余的消息发送完之后断开
+func (c *Connection) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// 带原因的关闭链接
+func (c *Connection) closeWith(reason string) {
+	c.closeOnce.Do(func() {
+		c.closeReason = reason
+		close(c.done)
+	})
+}
+
+// 客户端断开的时候可以保留会话的 hub
+type detacher interface {
+	detach(client *Connection)
+}
+
+// 测试中直接构造的链接没有设置日志
+func (c *Connection) logf() logger.Logger {
+	if c.logger == nil {
+		return logger.Nop()
+	}
+	return c.logger
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -83,27 +285,31 @@ type Connection struct {
 // reads from this goroutine.
 func (c *Connection) readPump() {
 	defer func() {
-		c.hub.Unregister(c.Id)
+		if d, ok := c.hub.(detacher); ok {
+			d.detach(c)
+		} else {
+			c.hub.Unregister(c.Id)
+		}
 		c.conn.Close()
 	}()
-	c.conn.SetReadLimit(c.options.maxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(c.options.pongWait))
+	c.conn.SetReadLimit(c.options.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.options.PongWait))
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(c.options.pongWait))
+		c.conn.SetReadDeadline(time.Now().Add(c.options.PongWait))
 		return nil
 	})
 	for {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
-			// if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-			// 	log.Printf("error: %v", err)
-			// }
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				c.logf().Debug("connection closed unexpectedly", "client_id", c.Id, "err", err)
+			}
 			break
 		}
 		message = bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
 
 		// 这里收到数据了，理论上要把数据抛出来
-		go c.hub.Dispatch(c.host, c.Id, wsmessage.CmdMessage, message, c.header)
+		c.hub.Receive(c, message)
 	}
 }
 
@@ -113,47 +319,65 @@ func (c *Connection) readPump() {
 // application ensures that there is at most one writer to a connection by
 // executing all writes from this goroutine.
 func (c *Connection) writePump() {
-	ticker := time.NewTicker(c.options.pingPeriod)
+	ticker := time.NewTicker(c.options.PingPeriod)
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
 	}()
 	for {
 		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(c.options.writeWait))
-			if !ok {
-				// The hub closed the channel.
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+		case message := <-c.send:
+			if err := c.write(message); err != nil {
+				c.logf().Warn("write message failed", "client_id", c.Id, "err", err)
 				return
 			}
-
-			byteType := c.options.byteType
-			if byteType == 0 {
-				byteType = websocket.TextMessage
-			}
-
-			w, err := c.conn.NextWriter(byteType)
-			if err != nil {
+		case <-c.done:
+			// The hub closed the connection.
+			if c.closeReason != "" {
+				// 被踢掉的链接不再发送积压的消息
+				c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, c.closeReason))
 				return
 			}
-			w.Write(message)
-
-			// Add queued chat messages to the current websocket message.
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write(newline)
-				w.Write(<-c.send)
-			}
-
-			if err := w.Close(); err != nil {
-				return
+			if len(c.send) > 0 {
+				c.write(<-c.send)
 			}
+			c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
+			c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(c.options.writeWait))
+			c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
 		}
 	}
 }
+
+// 发送一条消息，并把队列中已经有的消息合并到一起发送
+func (c *Connection) write(message []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
+
+	byteType := c.options.ByteType
+	if byteType == 0 {
+		byteType = websocket.TextMessage
+	}
+
+	w, err := c.conn.NextWriter(byteType)
+	if err != nil {
+		return err
+	}
+	w.Write(message)
+	c.metrics.MessageOut(len(message))
+
+	// Add queued chat messages to the current websocket message.
+	n := len(c.send)
+	for i := 0; i < n; i++ {
+		queued := <-c.send
+		w.Write(newline)
+		w.Write(queued)
+		c.metrics.MessageOut(len(queued))
+	}
+
+	return w.Close()
+}
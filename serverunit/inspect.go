@@ -0,0 +1,93 @@
+package serverunit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/hnchenkai/mx-wsgo/limitcount"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+var ErrClientNotFound = errors.New("client not found")
+
+// 链接的信息，给管理接口使用
+type ConnInfo struct {
+	Id          string      `json:"id"`
+	Host        string      `json:"host"`
+	Group       string      `json:"group"`
+	Status      string      `json:"status"`
+	Header      http.Header `json:"header"`
+	ConnectedAt time.Time   `json:"connectedAt"`
+	// 断开之后等待重连的会话
+	Detached bool `json:"detached"`
+}
+
+func newConnInfo(client *Connection) ConnInfo {
+	header := client.Header()
+	status := header.Get(wsmessage.WsStatusHeader)
+	if status == "" {
+		status = "pending"
+	}
+	return ConnInfo{
+		Id:          client.Id,
+		Host:        client.host,
+		Group:       header.Get(wsmessage.WsGroupHeader),
+		Status:      status,
+		Header:      header,
+		ConnectedAt: client.connectedAt,
+		Detached:    client.isDetached(),
+	}
+}
+
+// Connections 当前所有的链接，按建立链接的时间排序
+func (h *ServerUnit) Connections() []ConnInfo {
+	list := make([]ConnInfo, 0, h.clients.len())
+	h.clients.each(func(client *Connection) {
+		list = append(list, newConnInfo(client))
+	})
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ConnectedAt.Before(list[j].ConnectedAt)
+	})
+	return list
+}
+
+// Connection 获取一个链接的信息
+func (h *ServerUnit) Connection(clientId string) (ConnInfo, bool) {
+	client, ok := h.clients.get(clientId)
+	if !ok {
+		return ConnInfo{}, false
+	}
+	return newConnInfo(client), true
+}
+
+// Kick 踢掉一个链接，先给客户端发送带原因的关闭命令
+func (h *ServerUnit) Kick(clientId string, reason string) bool {
+	msg := h.GetConnMessage(clientId)
+	if msg == nil {
+		return false
+	}
+	msg.SetCloseMode(reason)
+	return true
+}
+
+// Promote 把排队中的链接直接转为接入
+func (h *ServerUnit) Promote(clientId string) error {
+	client, ok := h.clients.get(clientId)
+	if !ok {
+		return ErrClientNotFound
+	}
+	return h.limitcount.Promote(context.Background(), client.GetHeader(wsmessage.WsGroupHeader), clientId)
+}
+
+// WaitQueues 本节点每个分组的等待队列，按排队顺序
+func (h *ServerUnit) WaitQueues() map[string][]string {
+	return h.limitcount.WaitQueues()
+}
+
+// WaitPositions 本节点每个分组排队的链接和位置，全局排队模式是整个集群的位置
+func (h *ServerUnit) WaitPositions() map[string][]limitcount.WaitPosition {
+	return h.limitcount.WaitPositions()
+}
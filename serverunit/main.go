@@ -3,13 +3,20 @@ package serverunit
 import (
 	"context"
 	"fmt"
-	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/hnchenkai/mx-wsgo/auth"
 	"github.com/hnchenkai/mx-wsgo/bytecoder"
 	"github.com/hnchenkai/mx-wsgo/domain"
 	"github.com/hnchenkai/mx-wsgo/limitcount"
+	"github.com/hnchenkai/mx-wsgo/logger"
+	"github.com/hnchenkai/mx-wsgo/metrics"
+	"github.com/hnchenkai/mx-wsgo/tracing"
 	"github.com/hnchenkai/mx-wsgo/wsmessage"
 )
 
@@ -20,10 +27,7 @@ type Dispather func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage)
 // clients.
 type ServerUnit struct {
 	// Registered clients.
-	clients map[string]*Connection
-
-	// Inbound messages from the clients.
-	broadcast chan []byte
+	clients *clientRegistry
 
 	// Register requests from the clients.
 	register chan *Connection
@@ -31,40 +35,109 @@ type ServerUnit struct {
 	// Unregister requests from clients.
 	unregister chan string
 
+	// 会话配置，为空不保留断开的链接
+	sessions *SessionOptions
+	// 客户端断开和会话过期
+	detachCh chan *Connection
+	expireCh chan *Connection
+
 	genId int64
 
-	fclose domain.CloseSingal
+	fclose *domain.CloseSingal
 
 	dispatch Dispather
 
 	// needInitPb bool
 
 	limitcount *limitcount.LimitCountUnit
+
+	// 升级之前的鉴权器
+	authenticator auth.Authenticator
+
+	// 是否在升级之前判断限流状态
+	preAdmission bool
+	// 升级前被拒绝时 Retry-After 的时间
+	retryAfter time.Duration
+
+	// 所有链接的配置
+	clientOpts []ClientOption
+	// 按分组覆盖的链接配置
+	groupClientOpts map[string][]ClientOption
+
+	// 发送缓冲区配置
+	sendOpts *SendBufferOptions
+
+	// 有序分发的协程池，为空的时候每条消息一个协程
+	pool *domain.WorkerPool
+	// 有序分发模式下每个链接排队的消息上限，0使用默认值
+	dispatchClientLimit int
+	// 有序分发模式下仍然并发处理的路由
+	concurrentRoutes []string
+
+	// 包裹分发器的中间件
+	middlewares []Middleware
+
+	// 应答头的生成规则
+	headerPolicy *wsmessage.HeaderPolicy
+
+	// 根据链接的header计算排队的优先级，为空都是0
+	priority func(header http.Header) int
+
+	// 指标，为空不统计
+	metrics *metrics.Metrics
+
+	logger logger.Logger
+
+	// 追踪的钩子，为空不追踪
+	tracer tracing.Tracer
 }
 
 /**
  * @brief:  生成一个服务单元
  * @param:  dispatcher 分发器
  * @param:  limitOption 限流配置
+ * @param:  opts 其他可选配置
  * @return: *ServerUnit
  */
-func NewServerUnit(dispatcher Dispather, limitOption *limitcount.LimitOption) *ServerUnit {
+func NewServerUnit(dispatcher Dispather, limitOption *limitcount.LimitOption, opts ...Option) *ServerUnit {
 	unit := &ServerUnit{
-		broadcast:  make(chan []byte),
 		register:   make(chan *Connection),
 		unregister: make(chan string),
-		clients:    make(map[string]*Connection),
+		detachCh:   make(chan *Connection),
+		expireCh:   make(chan *Connection),
+		clients:    newClientRegistry(),
 		genId:      0,
+		fclose:     domain.NewCloseSingal(),
+		sendOpts:   defaultSendBufferOptions(),
+		logger:     logger.Default(),
 		dispatch:   dispatcher,
 		// needInitPb: initPb[0],
 	}
 
+	for _, opt := range opts {
+		opt(unit)
+	}
+	if len(unit.middlewares) > 0 {
+		unit.dispatch = Chain(unit.dispatch, unit.middlewares...)
+	}
+
+	if unit.pool != nil {
+		unit.pool.SetLogger(unit.logger)
+		unit.pool.SetKeyLimit(unit.dispatchClientLimit)
+	}
+
 	unit.limitcount = limitcount.NewLimitCountUnit(unit.GetConnMessage)
+	unit.limitcount.SetLogger(unit.logger)
 
 	if limitOption != nil {
 		unit.limitcount.Init(limitOption)
 	}
 
+	if unit.metrics != nil {
+		unit.limitcount.SetMetrics(unit.metrics)
+		unit.metrics.OnCollect(unit.collectConnections)
+	}
+
 	return unit
 }
 
@@ -73,139 +146,273 @@ func (h *ServerUnit) Close() {
 	h.limitcount.Close()
 	// 关闭ws链接
 	h.fclose.Close()
+	if h.pool != nil {
+		h.pool.Close()
+	}
 }
 
 // 这个是负责实现链接管理，断开链接，广播的转发
 func (h *ServerUnit) Run() {
 	h.limitcount.Run()
 	defer func() {
-		for clientId, client := range h.clients {
-			close(client.send)
-			delete(h.clients, clientId)
-		}
+		h.clients.each(func(client *Connection) {
+			h.clients.del(client.Id)
+			client.close()
+		})
 		h.fclose.Defer()
 	}()
 	for {
-		if h.fclose.Wait() {
-			break
-		}
 		select {
+		case <-h.fclose.WaitSingal():
+			return
 		case client := <-h.register:
-			h.clients[client.Id] = client
-			go h.Dispatch(client.host, client.Id, wsmessage.CmdAccept, nil, client.header)
+			h.onRegister(client)
+			// Allow collection of memory referenced by the caller by doing all work in
+			// new goroutines.
+			go client.writePump()
+			go client.readPump()
 		case clientId := <-h.unregister:
-			if client, ok := h.clients[clientId]; ok {
-				delete(h.clients, clientId)
-				close(client.send)
-				go h.Dispatch(client.host, client.Id, wsmessage.CmdClose, nil, client.header)
+			if client, ok := h.clients.get(clientId); ok {
+				h.remove(client)
 			}
-		case message := <-h.broadcast:
-			for clientId, client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, clientId)
-				}
+		case client := <-h.detachCh:
+			h.onDetach(client)
+		case client := <-h.expireCh:
+			h.onExpire(client)
+		}
+	}
+}
+
+// 在 Run 里面执行，注册新的链接或者恢复会话
+func (h *ServerUnit) onRegister(client *Connection) {
+	old := client.resumeFrom
+	client.resumeFrom = nil
+	resumed := false
+	if old != nil {
+		// 旧链接还在的时候沿用它的id和header，里面有分组、状态等信息
+		// 否则会话已经过期，按照新链接处理
+		if cur, ok := h.clients.get(old.Id); ok && cur == old {
+			resumed = true
+			client.Id = old.Id
+			client.header = old.Header()
+			client.outbox = old.outbox
+			if old.grace != nil {
+				old.grace.Stop()
 			}
+			old.close()
 		}
 	}
+	if h.sessions != nil && !resumed {
+		// 新的会话，会话信息要在所有消息之前发送
+		client.outbox = newOutbox(client, h.sessions)
+		client.tryPush(h.sessionFrame(client, false, 0))
+	}
+	h.clients.set(client)
+
+	h.event(client.Id, func() {
+		if resumed {
+			h.restore(client)
+			return
+		}
+		h.accept(client)
+	})
+}
+
+// 在 Run 里面执行，注销链接并通知分发器
+func (h *ServerUnit) remove(client *Connection) {
+	h.clients.del(client.Id)
+	if client.grace != nil {
+		client.grace.Stop()
+	}
+	client.close()
+	header := client.Header()
+	h.event(client.Id, func() {
+		h.Dispatch(client.host, client.Id, wsmessage.CmdClose, nil, header)
+	})
 }
 
-// 这个是负责消息广播的
+// 这个是负责消息广播的，缓冲区满了的链接按照发送策略处理
+// 阻塞模式下每个缓冲区满了的链接最多等待 SendBufferOptions.Timeout
 func (h *ServerUnit) Broadcast(message []byte) {
-	h.broadcast <- message
+	h.clients.each(func(client *Connection) {
+		h.deliver(client, message)
+	})
 }
 
 // 这个是关闭链接的
 func (h *ServerUnit) Unregister(clientId string) {
-	h.unregister <- clientId
+	select {
+	case h.unregister <- clientId:
+	case <-h.fclose.Done():
+	}
 }
 
 // 这个是生成
 func (h *ServerUnit) nextId() int64 {
-	var num int64 = 1
-	if h.genId > (num << 62) {
-		h.genId = 0
-	}
-	h.genId++
-
-	return h.genId
+	return atomic.AddInt64(&h.genId, 1)
 }
 
 func (h *ServerUnit) Send(clientId string, message []byte) bool {
-	client, ok := h.clients[clientId]
-	if ok {
-		client.send <- message
-		return true
-	} else {
+	client, ok := h.clients.get(clientId)
+	if !ok {
 		return false
 	}
+	return h.deliver(client, message)
+}
+
+// 获取head信息
+func (h *ServerUnit) GetHeader(clientId string, key string) string {
+	client, ok := h.clients.get(clientId)
+	if !ok {
+		return ""
+	}
+	return client.GetHeader(key)
 }
 
 // 添加head信息
 func (h *ServerUnit) AddHeader(clientId string, key string, value string) bool {
-	client, ok := h.clients[clientId]
+	client, ok := h.clients.get(clientId)
 	if !ok {
 		return false
 	}
-	client.header.Add(key, value)
+	client.AddHeader(key, value)
 	return true
 }
 
 func (h *ServerUnit) SetHeader(clientId string, key string, value string) bool {
-	client, ok := h.clients[clientId]
+	client, ok := h.clients.get(clientId)
 	if !ok {
 		return false
 	}
-	client.header.Set(key, value)
+	client.SetHeader(key, value)
 	return true
 }
 
 // 删除head信息
 func (h *ServerUnit) DelHeader(clientId string, key string) bool {
-	client, ok := h.clients[clientId]
+	client, ok := h.clients.get(clientId)
 	if !ok {
 		return false
 	}
-	client.header.Del(key)
+	client.DelHeader(key)
 	return true
 }
 
+// 在线的链接数量
+func (h *ServerUnit) Count() int {
+	return h.clients.len()
+}
+
 // 可以挂载到一个http服务上去,从http升级到https
 // header中 携带 Mx-Ws- 会被转发到ws的header中
 func (h *ServerUnit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// 这里最好做一个权限校验，判断是否可以链接
+	// 升级之前做权限校验，判断是否可以链接
+	identity, err := h.authenticate(r)
+	if err != nil {
+		code := auth.StatusCode(err)
+		h.logger.Info("authentication failed", "remote", r.RemoteAddr, "code", code, "err", err)
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	prefix := r.Header.Get("Sec-Websocket-Accept")
+	client := &Connection{
+		Id:          fmt.Sprintf("%s_%d", prefix, h.nextId()),
+		hub:         h,
+		host:        r.Host,
+		header:      newConnHeader(r, identity),
+		metrics:     h.metrics,
+		connectedAt: time.Now(),
+	}
+
+	// 带着有效的会话token重连，注册的时候恢复旧链接，不再判断限流状态
+	client.resumeFrom = h.resumable(r, client.header.Get(wsmessage.WsIdentityHeader))
+	if client.resumeFrom != nil {
+		client.resumeSeq = h.sessions.seqFrom(r)
+	}
+
+	// 升级之前判断限流状态，被拒绝的直接返回429，不再浪费升级的资源
+	// 需要排队的在注册之后才放入等待队列，避免分配名额的时候还找不到链接
+	if h.preAdmission && client.resumeFrom == nil {
+		group := client.header.Get(wsmessage.WsGroupHeader)
+		status, err := h.limitcount.ReserveConnStatus(group, client.Id)
+		if err != nil || status == wsmessage.LimitReject {
+			h.logger.Info("connection rejected before upgrade", "client_id", client.Id, "group", group, "err", err)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(h.retryAfter.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		client.admission = status
+	}
+
 	conn, err := upgrader.Upgrade(w, r, http.Header{
 		"Sec-Websocket-Protocol": r.Header.Values("Sec-Websocket-Protocol"),
 	})
 	if err != nil {
-		log.Println(err)
+		h.logger.Warn("websocket upgrade failed", "client_id", client.Id, "group", client.header.Get(wsmessage.WsGroupHeader), "err", err)
+		if client.admission != "" {
+			// 升级失败，释放已经占用的名额
+			h.limitcount.CloseConnStatus(client.header.Get(wsmessage.WsGroupHeader), client.Id, client.admission)
+		}
 		return
 	}
 
-	prefix := r.Header.Get("Sec-Websocket-Accept")
-	opt := defaultOptions()
-	opt.byteType = 2
-	client := &Connection{
-		Id:      fmt.Sprintf("%s_%d", prefix, h.nextId()),
-		hub:     h,
-		conn:    conn,
-		send:    make(chan []byte, 256),
-		options: opt,
-		host:    r.Host,
-		header:  http.Header{},
+	client.conn = conn
+	client.send = make(chan []byte, h.sendOpts.BufferSize)
+	client.done = make(chan struct{})
+	group := client.header.Get(wsmessage.WsGroupHeader)
+	if client.resumeFrom != nil {
+		group = client.resumeFrom.GetHeader(wsmessage.WsGroupHeader)
+	}
+	client.options = h.clientOptions(group)
+	client.logger = h.logger
+
+	select {
+	case h.register <- client:
+	case <-h.fclose.Done():
+		// 服务已经关闭了
+		conn.Close()
+	}
+}
+
+// 获取分组对应的链接配置，分组配置覆盖全局配置
+func (h *ServerUnit) clientOptions(group string) *ClientOptions {
+	opt := DefaultClientOptions()
+	for _, fn := range h.clientOpts {
+		fn(opt)
+	}
+	for _, fn := range h.groupClientOpts[group] {
+		fn(opt)
+	}
+	opt.normalize()
+	return opt
+}
+
+// 执行鉴权，没有配置鉴权器的时候直接放行
+func (h *ServerUnit) authenticate(r *http.Request) (*auth.Identity, error) {
+	if h.authenticator == nil {
+		return nil, nil
 	}
+	return h.authenticator.Authenticate(r)
+}
 
+// 生成链接的header信息
+// 客户端通过 Mx-Ws- 前缀转发的头不能设置 Mx-Wsgo- 开头的内部头，例如排队优先级和链接状态
+func newConnHeader(r *http.Request, identity *auth.Identity) http.Header {
+	header := http.Header{}
 	for k, v := range r.Header {
 		if strings.HasPrefix(k, wsmessage.PrefixProxyHeader) {
-			k1 := strings.Replace(k, wsmessage.PrefixProxyHeader, "", 1)
-			client.header[k1] = v
+			k1 := http.CanonicalHeaderKey(strings.Replace(k, wsmessage.PrefixProxyHeader, "", 1))
+			if strings.HasPrefix(k1, wsmessage.PrefixLocalHeader) {
+				continue
+			}
+			header[k1] = v
 		}
 	}
 
 	for key, value := range r.Header {
 		switch key {
+		case wsmessage.WsGroupHeader:
 		case "User-Agent":
 		// case "Cache-Control":
 		// case "Accept-Language":
@@ -213,39 +420,88 @@ func (h *ServerUnit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		default:
 			continue
 		}
-		client.header[key] = value
+		header[key] = value
 	}
 
-	h.register <- client
+	// 鉴权的结果放在最后，避免被客户端的头覆盖，没有值的属性也不能保留客户端转发的头
+	if identity != nil {
+		for k, v := range identity.Attrs {
+			if v == "" {
+				header.Del(k)
+				continue
+			}
+			header.Set(k, v)
+		}
+		header.Set(wsmessage.WsIdentityHeader, identity.Id)
+	}
 
-	// Allow collection of memory referenced by the caller by doing all work in
-	// new goroutines.
-	go client.writePump()
-	go client.readPump()
+	return header
 }
 
 func (h *ServerUnit) doDispatch(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
-	if h.dispatch != nil {
+	if h.dispatch == nil {
+		return
+	}
+	if h.metrics == nil && h.tracer == nil {
 		h.dispatch(cmd, msg)
 		return
 	}
+	msg.Trace(tracing.Event{Stage: tracing.StageDispatchStart, Cmd: string(cmd)})
+	start := time.Now()
+	h.dispatch(cmd, msg)
+	cost := time.Since(start)
+	h.metrics.ObserveDispatch(string(cmd), cost)
+	msg.Trace(tracing.Event{Stage: tracing.StageDispatchEnd, Cmd: string(cmd), Duration: cost})
+}
+
+// 解码客户端的消息，失败的时候应答错误
+func (h *ServerUnit) decode(msg *wsmessage.WSMessage, message []byte) bool {
+	err := msg.FromPb(message)
+	msg.Trace(tracing.Event{Stage: tracing.StageDecode, Err: err})
+	if err != nil {
+		h.logger.Warn("decode message failed", "client_id", msg.ClientId, "group", msg.Group(), "err", err)
+		msg.SendError(http.StatusBadRequest, err.Error(), nil)
+		return false
+	}
+	return true
+}
+
+// 按分组和状态统计当前的链接数
+func (h *ServerUnit) collectConnections() {
+	h.metrics.Connections.Reset()
+	h.clients.each(func(client *Connection) {
+		status := client.GetHeader(wsmessage.WsStatusHeader)
+		if status == "" {
+			status = "pending"
+		}
+		h.metrics.Connections.With(client.GetHeader(wsmessage.WsGroupHeader), status).Inc()
+	})
 }
 
 func (h *ServerUnit) msgBind(msg *wsmessage.WSMessage) *wsmessage.WSMessage {
 	clientId := msg.ClientId
+	if msg.OrgHeader == nil {
+		msg.OrgHeader = http.Header{}
+	}
+	msg.HeaderPolicy = h.headerPolicy
+	msg.Tracer = h.tracer
 	msg.Send = func(message []byte) bool {
 		return h.Send(clientId, message)
 	}
 	msg.Close = func() {
 		h.Unregister(clientId)
 	}
+	// 修改链接header的同时，同步修改消息里面的拷贝
 	msg.AddHeader = func(key string, value string) bool {
+		msg.OrgHeader.Add(key, value)
 		return h.AddHeader(clientId, key, value)
 	}
 	msg.DelHeader = func(key string) bool {
+		msg.OrgHeader.Del(key)
 		return h.DelHeader(clientId, key)
 	}
 	msg.SetHeader = func(key string, value string) bool {
+		msg.OrgHeader.Set(key, value)
 		return h.SetHeader(clientId, key, value)
 	}
 
@@ -254,14 +510,14 @@ func (h *ServerUnit) msgBind(msg *wsmessage.WSMessage) *wsmessage.WSMessage {
 
 // 获取一个链接对象，负责主动发送消息
 func (h *ServerUnit) GetConnMessage(clientId string) *wsmessage.WSMessage {
-	client, ok := h.clients[clientId]
+	client, ok := h.clients.get(clientId)
 	if !ok {
 		return nil
 	}
 	return h.msgBind(&wsmessage.WSMessage{
 		ClientId:  clientId,
 		Host:      client.host,
-		OrgHeader: client.header,
+		OrgHeader: client.Header(),
 	})
 }
 
@@ -275,48 +531,18 @@ func (h *ServerUnit) Dispatch(host string, clientId string, cmd wsmessage.Cmd, m
 	switch cmd {
 	case wsmessage.CmdMessage:
 		// 这里要么pass掉，要么回复一个错误消息
-		if err := msg.FromPb(message); err != nil {
-			msg.SendError(http.StatusBadRequest, err.Error(), nil)
-			return
-		}
-		if msg.Version == int(bytecoder.Version_VERSION_CMD) {
-			switch msg.Cmd {
-			case bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_REQ:
-				self, total := h.WaitUnitInfo(context.Background(), msg.Group(), msg.ClientId)
-				msg.WaitResponse(self, total)
-			default:
-				// 其他消息
-				h.doDispatch(wsmessage.CmdCmd, msg)
-			}
-		} else if msg.IsAccept() {
-			h.doDispatch(cmd, msg)
-		} else {
-			// 回复一个消息，告诉客户端需要等待接入
-			msg.SendError(http.StatusBadRequest, "need accept", nil)
+		if h.decode(msg, message) {
+			h.handleMessage(msg)
 		}
 	case wsmessage.CmdAccept:
 		// 进行一个是否限制链接的判断
-		if status, err := h.limitcount.MakeConnStatus(msg.Group(), msg.ClientId); err != nil {
-			msg.SetCloseMode(err.Error())
-		} else {
-			switch status {
-			case wsmessage.LimitAccept:
-				msg.SetAcceptMode()
-				h.doDispatch(cmd, msg)
-			case wsmessage.LimitWait:
-				self, total := h.WaitUnitInfo(context.Background(), msg.Group(), msg.ClientId)
-				msg.SetWaitMode(self, total)
-				h.doDispatch(wsmessage.CmdWait, msg)
-			case wsmessage.LimitReject:
-				msg.SetCloseMode("too many requests")
-				h.doDispatch(wsmessage.CmdReject, msg)
-			}
-		}
+		status, err := h.limitcount.MakeConnStatusPriority(msg.Group(), msg.ClientId, h.lane(msg.OrgHeader))
+		h.admit(msg, status, err)
 	case wsmessage.CmdClose:
 		// 这里把send无效化掉
 		msg.Send = func(message []byte) bool {
 			// 这里就不发送消息了
-			fmt.Println("CmdClose的时候不要发送消息了")
+			h.logger.Debug("send after close ignored", "client_id", msg.ClientId, "group", msg.Group())
 			return false
 		}
 		h.limitcount.CloseConnStatus(msg.Group(), msg.ClientId, msg.Status())
@@ -325,7 +551,140 @@ func (h *ServerUnit) Dispatch(host string, clientId string, cmd wsmessage.Cmd, m
 
 }
 
+// 处理解码之后的消息
+func (h *ServerUnit) handleMessage(msg *wsmessage.WSMessage) {
+	if msg.Version == int(bytecoder.Version_VERSION_CMD) {
+		switch msg.Cmd {
+		case bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_REQ:
+			msg.WaitResponseInfo(h.WaitInfo(context.Background(), msg.Group(), msg.ClientId))
+		default:
+			// 其他消息
+			h.doDispatch(wsmessage.CmdCmd, msg)
+		}
+	} else if msg.IsAccept() {
+		h.doDispatch(wsmessage.CmdMessage, msg)
+	} else {
+		// 回复一个消息，告诉客户端需要等待接入
+		msg.SendError(http.StatusBadRequest, "need accept", nil)
+	}
+}
+
+// 收到客户端的消息
+// 默认每条消息一个协程，开启有序分发后同一个链接的消息按顺序交给协程池处理
+func (h *ServerUnit) Receive(client *Connection, message []byte) {
+	h.metrics.MessageIn(len(message))
+	if h.tracer != nil {
+		tracing.Emit(h.tracer, context.Background(), tracing.Event{
+			Stage:    tracing.StageReceive,
+			ClientId: client.Id,
+			Group:    client.GetHeader(wsmessage.WsGroupHeader),
+			Size:     len(message),
+		})
+	}
+	if h.pool == nil {
+		go h.Dispatch(client.host, client.Id, wsmessage.CmdMessage, message, client.Header())
+		return
+	}
+
+	msg := h.msgBind(&wsmessage.WSMessage{
+		ClientId:  client.Id,
+		Host:      client.host,
+		OrgHeader: client.Header(),
+	})
+	if !h.decode(msg, message) {
+		return
+	}
+	if h.isConcurrentRoute(msg.Route) {
+		go h.handleMessage(msg)
+		return
+	}
+	// 只有这个链接自己排队满了才会暂停读取，所有链接的排队总数满了不等待，按照慢消费者策略处理
+	switch h.pool.TrySubmit(client.Id, func() {
+		h.handleMessage(msg)
+	}) {
+	case nil:
+	case domain.ErrPoolFull:
+		h.logger.Warn("dispatch queue full", "client_id", client.Id, "route", msg.Route)
+		if h.sendOpts.Policy == SlowConsumerDisconnect {
+			h.evict(client, h.sendOpts.CloseReason)
+			return
+		}
+		msg.SendError(http.StatusServiceUnavailable, "server busy", nil)
+	default:
+		// 协程池已经关闭了
+		go h.handleMessage(msg)
+	}
+}
+
+// 链接的生命周期事件，有序分发模式下和消息在同一个队列里面
+func (h *ServerUnit) event(clientId string, fn func()) {
+	if h.pool == nil || !h.pool.Enqueue(clientId, fn) {
+		go fn()
+	}
+}
+
+// 是否是允许并发处理的路由
+func (h *ServerUnit) isConcurrentRoute(route string) bool {
+	for _, v := range h.concurrentRoutes {
+		if v == route || (strings.HasSuffix(v, "*") && strings.HasPrefix(route, strings.TrimSuffix(v, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// 新链接注册完成，升级之前已经判断过状态的直接使用
+func (h *ServerUnit) accept(client *Connection) {
+	if client.admission == "" {
+		h.Dispatch(client.host, client.Id, wsmessage.CmdAccept, nil, client.Header())
+		return
+	}
+
+	msg := h.msgBind(&wsmessage.WSMessage{
+		ClientId:  client.Id,
+		Host:      client.host,
+		OrgHeader: client.Header(),
+	})
+	if client.admission == wsmessage.LimitWait {
+		h.limitcount.EnqueueWait(msg.Group(), client.Id, h.lane(msg.OrgHeader))
+	}
+	h.admit(msg, client.admission, nil)
+}
+
+// 根据限流状态通知客户端
+func (h *ServerUnit) admit(msg *wsmessage.WSMessage, status wsmessage.LimitStatus, err error) {
+	if err != nil {
+		h.logger.Info("connection rejected", "client_id", msg.ClientId, "group", msg.Group(), "status", status, "err", err)
+		msg.SetCloseMode(err.Error())
+		return
+	}
+	switch status {
+	case wsmessage.LimitAccept:
+		msg.SetAcceptMode()
+		h.doDispatch(wsmessage.CmdAccept, msg)
+	case wsmessage.LimitWait:
+		msg.SetWaitModeInfo(h.WaitInfo(context.Background(), msg.Group(), msg.ClientId))
+		h.doDispatch(wsmessage.CmdWait, msg)
+	case wsmessage.LimitReject:
+		msg.SetCloseMode("too many requests")
+		h.doDispatch(wsmessage.CmdReject, msg)
+	}
+}
+
+// 链接的排队优先级
+func (h *ServerUnit) lane(header http.Header) int {
+	if h.priority == nil {
+		return 0
+	}
+	return h.priority(header)
+}
+
 // WaitUnitInfo 获取等待队列信息
 func (h *ServerUnit) WaitUnitInfo(ctx context.Context, limitkey string, clientId string) (int64, int64) {
 	return h.limitcount.WaitUnitInfo(ctx, limitkey, clientId)
 }
+
+// WaitInfo 获取等待队列信息，带上预计的等待时间和接入速度
+func (h *ServerUnit) WaitInfo(ctx context.Context, limitkey string, clientId string) *bytecoder.MessageWaitInfo {
+	return h.limitcount.WaitInfo(ctx, limitkey, clientId)
+}
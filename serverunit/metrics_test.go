@@ -0,0 +1,84 @@
+package serverunit_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hnchenkai/mx-wsgo/limitcount"
+	"github.com/hnchenkai/mx-wsgo/metrics"
+	"github.com/hnchenkai/mx-wsgo/router"
+	"github.com/hnchenkai/mx-wsgo/serverunit"
+	"github.com/hnchenkai/mx-wsgo/wsclient"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+func TestMetrics(t *testing.T) {
+	m := metrics.New()
+	r := router.New()
+	r.Handle("", "/echo", func(ctx context.Context, msg *wsmessage.WSMessage) (int32, []byte, map[string]string, error) {
+		return 200, msg.Message, nil, nil
+	})
+	unit := serverunit.NewServerUnit(r.Dispatch, &limitcount.LimitOption{
+		ReadyLimitFunc: func(limitkey string) int {
+			return 1
+		},
+		WaitLimitFunc: func(limitkey string) int {
+			return 0
+		},
+	}, serverunit.WithMetrics(m))
+	go unit.Run()
+	defer unit.Close()
+	svr := httptest.NewServer(unit)
+	defer svr.Close()
+	url := "ws" + strings.TrimPrefix(svr.URL, "http")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := wsclient.Dial(ctx, url, &wsclient.Options{Group: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.WaitAccept(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Request(ctx, "GET", "/echo", []byte("hello"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	rejected := make(chan string, 1)
+	other, err := wsclient.Dial(ctx, url, &wsclient.Options{
+		Group: "test",
+		OnClose: func(reason string) {
+			rejected <- reason
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	select {
+	case <-rejected:
+	case <-ctx.Done():
+		t.Fatal("second client was not rejected")
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`mxwsgo_connections{group="test",status="accept"} 1`,
+		`mxwsgo_messages_total{direction="in"} 1`,
+		`mxwsgo_pool_ready{limitkey="test"} 1`,
+		`mxwsgo_pool_wait{limitkey="test"} 0`,
+		`mxwsgo_rejections_total{limitkey="test"} 1`,
+		`mxwsgo_dispatch_duration_seconds_count{cmd="message"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, body)
+		}
+	}
+}
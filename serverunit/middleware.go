@@ -0,0 +1,66 @@
+package serverunit
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/hnchenkai/mx-wsgo/logger"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+// 中间件，包裹分发器，所有类型的 wsmessage.Cmd 都会经过
+// 不调用 next 就表示中断，这时可以使用 msg.SendError 应答错误
+type Middleware func(next Dispather) Dispather
+
+// Chain 组合中间件，第一个中间件在最外层最先执行
+func Chain(dispatcher Dispather, mws ...Middleware) Dispather {
+	if dispatcher == nil {
+		dispatcher = func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {}
+	}
+	for i := len(mws) - 1; i >= 0; i-- {
+		dispatcher = mws[i](dispatcher)
+	}
+	return dispatcher
+}
+
+// Recovery 捕获分发器中的panic，消息类型的请求会应答500
+func Recovery() Middleware {
+	return RecoveryWithLogger(logger.Default())
+}
+
+// RecoveryWithLogger 和 Recovery 一样，panic 使用指定的日志输出
+func RecoveryWithLogger(l logger.Logger) Middleware {
+	return func(next Dispather) Dispather {
+		return func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
+			defer func() {
+				if err := recover(); err != nil {
+					l.Error("dispatch panic", "cmd", cmd, "client_id", msg.ClientId, "group", msg.Group(), "route", msg.Route, "err", fmt.Sprint(err), "stack", string(debug.Stack()))
+					if cmd == wsmessage.CmdMessage {
+						msg.SendError(http.StatusInternalServerError, "internal server error", nil)
+					}
+				}
+			}()
+			next(cmd, msg)
+		}
+	}
+}
+
+// Logging 记录每次分发的信息和耗时，l 为空的时候使用 logger.Default()
+func Logging(l logger.Logger) Middleware {
+	if l == nil {
+		l = logger.Default()
+	}
+	return func(next Dispather) Dispather {
+		return func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
+			start := time.Now()
+			next(cmd, msg)
+			if cmd == wsmessage.CmdMessage {
+				l.Info("dispatch", "cmd", cmd, "client_id", msg.ClientId, "group", msg.Group(), "request_id", msg.ReqId, "method", msg.Method, "route", msg.Route, "duration", time.Since(start))
+			} else {
+				l.Info("dispatch", "cmd", cmd, "client_id", msg.ClientId, "group", msg.Group(), "duration", time.Since(start))
+			}
+		}
+	}
+}
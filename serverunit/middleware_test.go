@@ -0,0 +1,96 @@
+package serverunit_test
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
+	"github.com/hnchenkai/mx-wsgo/serverunit"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	var order []string
+	mark := func(name string) serverunit.Middleware {
+		return func(next serverunit.Dispather) serverunit.Dispather {
+			return func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
+				order = append(order, name+">")
+				next(cmd, msg)
+				order = append(order, "<"+name)
+			}
+		}
+	}
+	// 中断后面的处理
+	deny := func(next serverunit.Dispather) serverunit.Dispather {
+		return func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
+			if msg.Route == "/deny" {
+				msg.SendError(http.StatusForbidden, "deny", nil)
+				return
+			}
+			next(cmd, msg)
+		}
+	}
+
+	dispatcher := serverunit.Chain(func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
+		order = append(order, "handler")
+	}, mark("a"), mark("b"), deny)
+
+	dispatcher(wsmessage.CmdMessage, &wsmessage.WSMessage{Route: "/ok"})
+	if want := []string{"a>", "b>", "handler", "<b", "<a"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("unexpected order %v", order)
+	}
+
+	order = nil
+	var sent []byte
+	dispatcher(wsmessage.CmdMessage, &wsmessage.WSMessage{Route: "/deny", Send: func(message []byte) bool {
+		sent = message
+		return true
+	}})
+	if want := []string{"a>", "b>", "<b", "<a"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("unexpected order %v", order)
+	}
+	if len(sent) == 0 {
+		t.Fatal("short-circuit response not sent")
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	var sent []byte
+	dispatcher := serverunit.Chain(func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
+		panic("boom")
+	}, serverunit.Recovery())
+
+	dispatcher(wsmessage.CmdMessage, &wsmessage.WSMessage{
+		OrgHeader: http.Header{},
+		Send: func(message []byte) bool {
+			sent = message
+			return true
+		},
+	})
+
+	coder := bytecoder.StreamCoder(sent)
+	coder.DecodeWS()
+	coder.UnGzip()
+	resp, err := coder.UnmarshalV0()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetCode() != http.StatusInternalServerError {
+		t.Fatalf("unexpected code %d", resp.GetCode())
+	}
+}
+
+func TestLogging(t *testing.T) {
+	l := &recordLogger{}
+	dispatcher := serverunit.Chain(func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {}, serverunit.Logging(l))
+	dispatcher(wsmessage.CmdMessage, &wsmessage.WSMessage{ClientId: "c1", Route: "/ping", OrgHeader: http.Header{}})
+
+	entry := l.find("INFO dispatch")
+	for _, v := range []string{"client_id c1", "cmd", "route /ping", "duration"} {
+		if !strings.Contains(entry, v) {
+			t.Fatalf("missing %q in %q", v, entry)
+		}
+	}
+}
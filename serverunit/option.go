@@ -0,0 +1,147 @@
+package serverunit
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hnchenkai/mx-wsgo/auth"
+	"github.com/hnchenkai/mx-wsgo/domain"
+	"github.com/hnchenkai/mx-wsgo/logger"
+	"github.com/hnchenkai/mx-wsgo/metrics"
+	"github.com/hnchenkai/mx-wsgo/tracing"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+// 服务单元的可选配置
+type Option func(*ServerUnit)
+
+// 设置鉴权器，在升级ws之前执行，鉴权失败直接返回http错误码
+func WithAuthenticator(authenticator auth.Authenticator) Option {
+	return func(h *ServerUnit) {
+		h.authenticator = authenticator
+	}
+}
+
+// 在升级ws之前判断限流状态，被拒绝的链接直接返回429
+// retryAfter 为返回给客户端的 Retry-After 时间，默认5秒
+func WithPreUpgradeAdmission(retryAfter time.Duration) Option {
+	return func(h *ServerUnit) {
+		if retryAfter <= 0 {
+			retryAfter = 5 * time.Second
+		}
+		h.preAdmission = true
+		h.retryAfter = retryAfter
+	}
+}
+
+// 设置所有链接的配置
+func WithClientOptions(opts ...ClientOption) Option {
+	return func(h *ServerUnit) {
+		h.clientOpts = append(h.clientOpts, opts...)
+	}
+}
+
+// 设置某个分组(WsGroupHeader)的链接配置，在全局配置的基础上覆盖
+func WithGroupClientOptions(group string, opts ...ClientOption) Option {
+	return func(h *ServerUnit) {
+		if h.groupClientOpts == nil {
+			h.groupClientOpts = make(map[string][]ClientOption)
+		}
+		h.groupClientOpts[group] = append(h.groupClientOpts[group], opts...)
+	}
+}
+
+// 设置发送缓冲区大小和缓冲区满了之后的处理策略
+func WithSendBuffer(opt SendBufferOptions) Option {
+	return func(h *ServerUnit) {
+		opt.normalize()
+		h.sendOpts = &opt
+	}
+}
+
+// 开启有序分发，同一个链接的消息按顺序处理，所有链接共享一个有界的协程池
+// workers 协程数量，queueSize 排队消息的总上限，满了之后会暂停读取客户端的消息
+func WithOrderedDispatch(workers int, queueSize int) Option {
+	return func(h *ServerUnit) {
+		if h.pool != nil {
+			h.pool.Close()
+		}
+		h.pool = domain.NewWorkerPool(workers, queueSize)
+	}
+}
+
+// 有序分发模式下每个链接排队的消息上限，默认32，超过之后暂停读取这个链接的消息
+func WithDispatchClientLimit(n int) Option {
+	return func(h *ServerUnit) {
+		h.dispatchClientLimit = n
+	}
+}
+
+// 有序分发模式下，这些路由仍然每条消息一个协程并发处理，支持 * 结尾的前缀匹配
+func WithConcurrentRoutes(routes ...string) Option {
+	return func(h *ServerUnit) {
+		h.concurrentRoutes = append(h.concurrentRoutes, routes...)
+	}
+}
+
+// 添加分发器的中间件，按照添加的顺序执行，第一个在最外层
+func WithMiddleware(mws ...Middleware) Option {
+	return func(h *ServerUnit) {
+		h.middlewares = append(h.middlewares, mws...)
+	}
+}
+
+// 设置日志，兼容 *slog.Logger，级别可以使用 logger.WithLevel 控制
+func WithLogger(l logger.Logger) Option {
+	return func(h *ServerUnit) {
+		if l != nil {
+			h.logger = l
+		}
+	}
+}
+
+// 统计链接、消息、限流池的指标，一组指标对应一个服务单元
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(h *ServerUnit) {
+		h.metrics = m
+	}
+}
+
+// 开启会话，断开的链接在宽限期内带着token重连可以恢复原来的链接id、排队位置和接入名额
+func WithSessions(opt SessionOptions) Option {
+	return func(h *ServerUnit) {
+		opt.normalize()
+		h.sessions = &opt
+	}
+}
+
+// 设置追踪的钩子，在收到帧、解码、分发开始和结束、发送应答的时候调用
+func WithTracer(t tracing.Tracer) Option {
+	return func(h *ServerUnit) {
+		h.tracer = t
+	}
+}
+
+// 设置客户端要求返回应答头(response_header)时的过滤规则
+func WithResponseHeaderPolicy(policy wsmessage.HeaderPolicy) Option {
+	return func(h *ServerUnit) {
+		h.headerPolicy = &policy
+	}
+}
+
+// 设置排队的优先级，根据链接的header(包括鉴权写入的结果)计算，对应 LimitOption.LaneWeights 的下标
+func WithPriority(fn func(header http.Header) int) Option {
+	return func(h *ServerUnit) {
+		h.priority = fn
+	}
+}
+
+// 从header中读取数字作为优先级，例如鉴权器写入的 wsmessage.WsPriorityHeader
+// 客户端可以通过 Mx-Ws- 前缀设置 Mx-Wsgo- 以外的任意header，不要使用客户端可以控制的头
+func HeaderPriority(key string) func(header http.Header) int {
+	return func(header http.Header) int {
+		lane, _ := strconv.Atoi(header.Get(key))
+		return lane
+	}
+}
@@ -0,0 +1,228 @@
+package serverunit_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
+	"github.com/hnchenkai/mx-wsgo/serverunit"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+func TestGroupClientOptions(t *testing.T) {
+	unit := serverunit.NewServerUnit(func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {}, nil,
+		serverunit.WithGroupClientOptions("bulk", serverunit.WithMaxMessageSize(1<<20)))
+	go unit.Run()
+
+	svr := httptest.NewServer(unit)
+	defer svr.Close()
+	url := "ws" + strings.TrimPrefix(svr.URL, "http")
+	big := bytes.Repeat([]byte{'a'}, 8192)
+
+	send := func(group string) error {
+		header := http.Header{}
+		header.Set(wsmessage.WsGroupHeader, group)
+		conn, _, err := websocket.DefaultDialer.Dial(url, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		// 先读取accept消息
+		if _, _, err := conn.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, big); err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = conn.ReadMessage()
+		return err
+	}
+
+	if err := send("lobby"); err == nil {
+		t.Fatal("expected default group to close oversized message")
+	}
+	if err := send("bulk"); err != nil {
+		t.Fatalf("bulk group should accept large message: %v", err)
+	}
+}
+
+// 记录日志的内容
+type recordLogger struct {
+	lock    sync.Mutex
+	entries []string
+}
+
+func (l *recordLogger) record(level, msg string, args []any) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.entries = append(l.entries, fmt.Sprint(level, " ", msg, " ", args))
+}
+
+func (l *recordLogger) Debug(msg string, args ...any) { l.record("DEBUG", msg, args) }
+func (l *recordLogger) Info(msg string, args ...any)  { l.record("INFO", msg, args) }
+func (l *recordLogger) Warn(msg string, args ...any)  { l.record("WARN", msg, args) }
+func (l *recordLogger) Error(msg string, args ...any) { l.record("ERROR", msg, args) }
+
+func (l *recordLogger) find(prefix string) string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, v := range l.entries {
+		if strings.HasPrefix(v, prefix) {
+			return v
+		}
+	}
+	return ""
+}
+
+func TestLogger(t *testing.T) {
+	l := &recordLogger{}
+	unit := serverunit.NewServerUnit(func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {}, nil, serverunit.WithLogger(l))
+	go unit.Run()
+	defer unit.Close()
+
+	svr := httptest.NewServer(unit)
+	defer svr.Close()
+	url := "ws" + strings.TrimPrefix(svr.URL, "http")
+
+	header := http.Header{}
+	header.Set(wsmessage.WsGroupHeader, "test")
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	// 不是合法的消息
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte("not a frame")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := l.find("WARN decode message failed")
+	if entry == "" || !strings.Contains(entry, "group test") {
+		t.Fatalf("missing decode log: %q", entry)
+	}
+}
+
+// 版本号可以解析，但是内容损坏的消息，不能当成空请求分发
+func TestMalformedFrame(t *testing.T) {
+	l := &recordLogger{}
+	dispatched := make(chan struct{}, 1)
+	unit := serverunit.NewServerUnit(func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
+		if cmd == wsmessage.CmdMessage {
+			dispatched <- struct{}{}
+		}
+	}, nil, serverunit.WithLogger(l))
+	go unit.Run()
+	defer unit.Close()
+
+	svr := httptest.NewServer(unit)
+	defer svr.Close()
+	url := "ws" + strings.TrimPrefix(svr.URL, "http")
+
+	header := http.Header{}
+	header.Set(wsmessage.WsGroupHeader, "test")
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	// version=1 request_id=7，route 不是合法的utf8
+	coder := bytecoder.StreamCoder([]byte{0x08, 0x01, 0x10, 0x07, 0x22, 0x01, 0xff})
+	coder.Gzip()
+	coder.EncodeWS()
+	if err := conn.WriteMessage(websocket.BinaryMessage, coder.Byte()); err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := bytecoder.StreamCoder(data)
+	reply.DecodeWS()
+	reply.UnGzip()
+	resp, err := reply.UnmarshalV0()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetCode() != http.StatusBadRequest {
+		t.Fatalf("unexpected code %d", resp.GetCode())
+	}
+
+	entry := l.find("WARN decode message failed")
+	for _, v := range []string{"client_id", "group test", "unmarshal message"} {
+		if !strings.Contains(entry, v) {
+			t.Fatalf("missing %q in %q", v, entry)
+		}
+	}
+	select {
+	case <-dispatched:
+		t.Fatal("malformed frame dispatched")
+	default:
+	}
+}
+
+// 客户端通过 Mx-Ws- 前缀伪造内部的优先级头，不能进入高优先级通道
+func TestForgedPriority(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	lanes := make(chan int, 1)
+	unit := serverunit.NewServerUnit(func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
+		if cmd == wsmessage.CmdAccept {
+			headers <- msg.OrgHeader
+		}
+	}, nil, serverunit.WithPriority(func(header http.Header) int {
+		lane := serverunit.HeaderPriority(wsmessage.WsPriorityHeader)(header)
+		lanes <- lane
+		return lane
+	}))
+	go unit.Run()
+	defer unit.Close()
+	svr := httptest.NewServer(unit)
+	defer svr.Close()
+	url := "ws" + strings.TrimPrefix(svr.URL, "http")
+
+	header := http.Header{}
+	header.Set(wsmessage.PrefixProxyHeader+wsmessage.WsPriorityHeader, "1")
+	header.Set(wsmessage.PrefixProxyHeader+wsmessage.WsStatusHeader, "accept")
+	header.Set(wsmessage.PrefixProxyHeader+"Uid", "u1")
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	select {
+	case lane := <-lanes:
+		if lane != 0 {
+			t.Fatalf("forged priority used %d", lane)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("priority not computed")
+	}
+	select {
+	case hd := <-headers:
+		if hd.Get(wsmessage.WsPriorityHeader) != "" || hd.Get("Uid") != "u1" {
+			t.Fatalf("unexpected header %v", hd)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("accept timeout")
+	}
+}
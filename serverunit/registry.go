@@ -0,0 +1,87 @@
+package serverunit
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+const registryShards = 32
+
+type registryShard struct {
+	lock    sync.RWMutex
+	clients map[string]*Connection
+}
+
+// 分片加锁的链接表，降低大量链接时的锁竞争
+type clientRegistry struct {
+	shards [registryShards]*registryShard
+}
+
+func newClientRegistry() *clientRegistry {
+	r := &clientRegistry{}
+	for i := range r.shards {
+		r.shards[i] = &registryShard{
+			clients: make(map[string]*Connection),
+		}
+	}
+	return r
+}
+
+func (r *clientRegistry) shard(clientId string) *registryShard {
+	h := fnv.New32a()
+	h.Write([]byte(clientId))
+	return r.shards[h.Sum32()%registryShards]
+}
+
+func (r *clientRegistry) get(clientId string) (*Connection, bool) {
+	s := r.shard(clientId)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	client, ok := s.clients[clientId]
+	return client, ok
+}
+
+func (r *clientRegistry) set(client *Connection) {
+	s := r.shard(client.Id)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.clients[client.Id] = client
+}
+
+// 删除并返回被删除的链接
+func (r *clientRegistry) del(clientId string) (*Connection, bool) {
+	s := r.shard(clientId)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	client, ok := s.clients[clientId]
+	if ok {
+		delete(s.clients, clientId)
+	}
+	return client, ok
+}
+
+// 遍历所有链接，遍历的是快照，回调里面可以安全的修改链接表
+func (r *clientRegistry) each(fn func(client *Connection)) {
+	for _, s := range r.shards {
+		s.lock.RLock()
+		clients := make([]*Connection, 0, len(s.clients))
+		for _, client := range s.clients {
+			clients = append(clients, client)
+		}
+		s.lock.RUnlock()
+
+		for _, client := range clients {
+			fn(client)
+		}
+	}
+}
+
+func (r *clientRegistry) len() int {
+	total := 0
+	for _, s := range r.shards {
+		s.lock.RLock()
+		total += len(s.clients)
+		s.lock.RUnlock()
+	}
+	return total
+}
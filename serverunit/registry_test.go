@@ -0,0 +1,160 @@
+package serverunit_test
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hnchenkai/mx-wsgo/limitcount"
+	"github.com/hnchenkai/mx-wsgo/serverunit"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+// 多协程同时发送、广播、修改header、断开，配合 -race 检查
+func TestRegistryConcurrent(t *testing.T) {
+	const clientCount = 100
+
+	ids := make(chan string, clientCount)
+	unit := serverunit.NewServerUnit(func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
+		switch cmd {
+		case wsmessage.CmdAccept, wsmessage.CmdWait:
+			msg.AddHeader("Accepted-At", time.Now().String())
+			ids <- msg.ClientId
+		}
+	}, &limitcount.LimitOption{
+		ReadyLimitFunc: func(limitkey string) int {
+			return clientCount / 2
+		},
+		WaitLimitFunc: func(limitkey string) int {
+			return -1
+		},
+	})
+	go unit.Run()
+
+	svr := httptest.NewServer(unit)
+	defer svr.Close()
+	url := "ws" + strings.TrimPrefix(svr.URL, "http")
+
+	conns := make([]*websocket.Conn, 0, clientCount)
+	for i := 0; i < clientCount; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	clientIds := make([]string, 0, clientCount)
+	for i := 0; i < clientCount; i++ {
+		select {
+		case id := <-ids:
+			clientIds = append(clientIds, id)
+		case <-time.After(5 * time.Second):
+			t.Fatal("accept timeout")
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, clientId := range clientIds {
+		wg.Add(4)
+		go func(clientId string) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				unit.Send(clientId, []byte(fmt.Sprint(j)))
+			}
+		}(clientId)
+		go func(i int) {
+			defer wg.Done()
+			unit.Broadcast([]byte(fmt.Sprint("broadcast", i)))
+		}(i)
+		go func(clientId string) {
+			defer wg.Done()
+			unit.SetHeader(clientId, "Foo", "bar")
+			unit.DelHeader(clientId, "Foo")
+			if msg := unit.GetConnMessage(clientId); msg != nil {
+				msg.Group()
+			}
+		}(clientId)
+		go func(i int, clientId string) {
+			defer wg.Done()
+			if i%2 == 0 {
+				unit.Unregister(clientId)
+			}
+		}(i, clientId)
+	}
+	wg.Wait()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	unit.Close()
+	if unit.Count() != 0 {
+		t.Fatalf("clients left after close: %d", unit.Count())
+	}
+}
+
+// 关闭的同时还有链接在注册和注销，Close 不能被卡住
+func TestCloseWhileRegistering(t *testing.T) {
+	for round := 0; round < 5; round++ {
+		unit := serverunit.NewServerUnit(nil, nil)
+		go unit.Run()
+		svr := httptest.NewServer(unit)
+		url := "ws" + strings.TrimPrefix(svr.URL, "http")
+
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					if conn, _, err := websocket.DefaultDialer.Dial(url, nil); err == nil {
+						conn.Close()
+					}
+				}
+			}()
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; ; j++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					unit.Unregister(fmt.Sprint("client", i, "_", j))
+				}
+			}(i)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		done := make(chan struct{})
+		go func() {
+			unit.Close()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("close blocked in round %d", round)
+		}
+		close(stop)
+		wg.Wait()
+		svr.Close()
+	}
+}
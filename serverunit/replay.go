@@ -0,0 +1,182 @@
+package serverunit
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+	"google.golang.org/protobuf/proto"
+)
+
+// 重连时没有带已经收到的序号
+const noSeq int64 = -1
+
+type replayEntry struct {
+	seq  int64
+	data []byte
+	at   time.Time
+}
+
+// 一个逻辑会话的发送序号和重放缓冲区，重连之后由新的链接接管
+// 除了会话和重新同步命令，每条放入发送队列的消息序号加一，客户端按收到的条数计数
+type outbox struct {
+	lock sync.Mutex
+	// 当前的链接，断开等待重连的时候是已经关闭的旧链接
+	conn    *Connection
+	seq     int64
+	entries []replayEntry
+	size    int
+	age     time.Duration
+}
+
+func newOutbox(client *Connection, opts *SessionOptions) *outbox {
+	return &outbox{
+		conn: client,
+		size: opts.ReplaySize,
+		age:  opts.ReplayAge,
+	}
+}
+
+// 分配序号并放入缓冲区，需要持有锁
+func (b *outbox) record(message []byte) {
+	b.seq++
+	if b.size <= 0 {
+		return
+	}
+	b.entries = append(b.entries, replayEntry{seq: b.seq, data: message, at: time.Now()})
+	if n := len(b.entries) - b.size; n > 0 {
+		b.entries = append(b.entries[:0:0], b.entries[n:]...)
+	}
+}
+
+// 客户端已经收到 last 之后需要重放的消息，缺失的消息已经不在缓冲区的时候返回false
+func (b *outbox) since(last int64) ([][]byte, bool) {
+	expire := time.Now().Add(-b.age)
+	i := 0
+	for i < len(b.entries) && b.entries[i].at.Before(expire) {
+		i++
+	}
+	b.entries = b.entries[i:]
+
+	if last < 0 || last > b.seq {
+		return nil, false
+	}
+	if last == b.seq {
+		return nil, true
+	}
+	if len(b.entries) == 0 || b.entries[0].seq > last+1 {
+		return nil, false
+	}
+	frames := make([][]byte, 0, b.seq-last)
+	for _, v := range b.entries[last+1-b.entries[0].seq:] {
+		frames = append(frames, v.data)
+	}
+	return frames, true
+}
+
+// 重连时客户端带的已经收到的最后一条消息的序号
+func (o *SessionOptions) seqFrom(r *http.Request) int64 {
+	v := r.Header.Get(wsmessage.WsSessionSeqHeader)
+	if v == "" {
+		v = r.URL.Query().Get(o.QueryParam + "_seq")
+	}
+	seq, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || seq < 0 {
+		return noSeq
+	}
+	return seq
+}
+
+// 会话模式的投递，序号在放入发送队列的时候分配，保证和客户端收到的顺序一致
+// 阻塞模式持有锁等待，同一个会话的其他投递也要排在后面，等待的时间不超过 Timeout
+// 丢弃最早的消息会让客户端的计数出错，所以按照丢弃最新的处理
+func (h *ServerUnit) deliverSeq(box *outbox, message []byte) bool {
+	opt := h.sendOpts
+	box.lock.Lock()
+	client := box.conn
+	if client.isClosed() {
+		// 断开等待重连，或者正在被新的链接接管，先放在重放缓冲区
+		box.record(message)
+		box.lock.Unlock()
+		return true
+	}
+	ok := client.tryPush(message)
+	if !ok && opt.Policy == SlowConsumerBlock {
+		ok = h.push(client, message, opt.Timeout) || client.isClosed()
+	}
+	if ok {
+		box.record(message)
+	}
+	box.lock.Unlock()
+	if ok {
+		return true
+	}
+
+	switch opt.Policy {
+	case SlowConsumerDisconnect:
+		h.metrics.SendDropped("disconnect")
+		h.evict(client, opt.CloseReason)
+	case SlowConsumerDropNewest, SlowConsumerDropOldest:
+		h.metrics.SendDropped("drop_newest")
+	}
+	return false
+}
+
+// 生成一条命令帧，不经过发送队列和序号
+func (h *ServerUnit) cmdFrame(client *Connection, cmd bytecoder.MsgLocalCmd, body []byte) []byte {
+	msg := h.msgBind(&wsmessage.WSMessage{
+		ClientId:  client.Id,
+		Host:      client.host,
+		OrgHeader: client.Header(),
+	})
+	var frame []byte
+	msg.Send = func(message []byte) bool {
+		frame = message
+		return true
+	}
+	msg.SendResponseCmd(cmd, body, nil)
+	return frame
+}
+
+func (h *ServerUnit) sessionFrame(client *Connection, resumed bool, seq int64) []byte {
+	bt, _ := proto.Marshal(&bytecoder.MessageSessionInfo{
+		Token:        h.sessions.sign(client.Id),
+		ClientId:     client.Id,
+		GraceSeconds: int64(h.sessions.Grace / time.Second),
+		Resumed:      resumed,
+		Seq:          seq,
+	})
+	return h.cmdFrame(client, bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_SESSION, bt)
+}
+
+// 新的链接接管会话：先下发会话信息，然后重放客户端没有收到的消息，之后才开始正常投递
+// 缺失的消息已经不在缓冲区的时候下发重新同步命令
+// 重放超时的时候断开新的链接，会话继续保留，客户端可以带着序号再次重连
+func (h *ServerUnit) takeover(client *Connection) bool {
+	box := client.outbox
+	box.lock.Lock()
+	defer box.lock.Unlock()
+
+	last := client.resumeSeq
+	frames, ok := box.since(last)
+	if !ok {
+		last = box.seq
+	}
+	pending := [][]byte{h.sessionFrame(client, true, last)}
+	if !ok && client.resumeSeq != noSeq {
+		h.logger.Info("replay gap too large", "client_id", client.Id, "seq", client.resumeSeq, "last_seq", box.seq)
+		pending = append(pending, h.cmdFrame(client, bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_RESYNC, []byte("消息缺失过多，需要重新同步")))
+	}
+	for _, v := range append(pending, frames...) {
+		if !client.pushTimeout(v, h.sendOpts.Timeout) {
+			h.logger.Info("replay timeout", "client_id", client.Id, "seq", client.resumeSeq, "last_seq", box.seq)
+			client.closeWith(h.sendOpts.CloseReason)
+			return false
+		}
+	}
+	box.conn = client
+	return true
+}
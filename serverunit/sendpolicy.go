@@ -0,0 +1,110 @@
+package serverunit
+
+import "time"
+
+// 发送缓冲区满了之后的处理方式
+type SlowConsumerPolicy int
+
+const (
+	// 阻塞调用者等待，超时后丢弃本条消息
+	SlowConsumerBlock SlowConsumerPolicy = iota
+	// 丢弃最新的消息(本条)
+	SlowConsumerDropNewest
+	// 丢弃最早的消息，放入本条
+	SlowConsumerDropOldest
+	// 断开链接
+	SlowConsumerDisconnect
+)
+
+// 发送缓冲区配置，Send 和 Broadcast 使用同一个策略
+type SendBufferOptions struct {
+	// 每个链接的发送缓冲区大小 默认256
+	BufferSize int
+	// 缓冲区满了之后的处理方式 默认阻塞
+	Policy SlowConsumerPolicy
+	// 阻塞模式的等待时间 默认1秒，不能一直等待，避免卡住的链接拖住调用者
+	// 会话恢复时重放消息也使用这个时间
+	Timeout time.Duration
+	// 断开模式下发送给客户端的原因
+	CloseReason string
+}
+
+func defaultSendBufferOptions() *SendBufferOptions {
+	return &SendBufferOptions{
+		BufferSize:  256,
+		Policy:      SlowConsumerBlock,
+		Timeout:     time.Second,
+		CloseReason: "slow consumer",
+	}
+}
+
+func (o *SendBufferOptions) normalize() {
+	if o.BufferSize <= 0 {
+		o.BufferSize = 256
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = time.Second
+	}
+	if o.CloseReason == "" {
+		o.CloseReason = "slow consumer"
+	}
+}
+
+// 按照策略投递消息，需要断开的链接走正常的注销流程
+// 阻塞模式在调用者的协程里面等待，不额外启动协程，保证消息的顺序
+func (h *ServerUnit) deliver(client *Connection, message []byte) bool {
+	if client.outbox != nil {
+		return h.deliverSeq(client.outbox, message)
+	}
+	if client.tryPush(message) {
+		return true
+	}
+	if client.isClosed() {
+		// 已经断开，包括等待重连的会话
+		return false
+	}
+
+	opt := h.sendOpts
+	switch opt.Policy {
+	case SlowConsumerDropNewest:
+		h.metrics.SendDropped("drop_newest")
+		return false
+	case SlowConsumerDropOldest:
+		for i := 0; i < 3; i++ {
+			if client.dropOldest() {
+				h.metrics.SendDropped("drop_oldest")
+			}
+			if client.tryPush(message) {
+				return true
+			}
+		}
+		h.metrics.SendDropped("drop_newest")
+		return false
+	case SlowConsumerDisconnect:
+		h.metrics.SendDropped("disconnect")
+		h.evict(client, opt.CloseReason)
+		return false
+	default:
+		return h.push(client, message, opt.Timeout)
+	}
+}
+
+// 阻塞模式的投递，超时丢弃的消息计入指标
+func (h *ServerUnit) push(client *Connection, message []byte, timeout time.Duration) bool {
+	if client.pushTimeout(message, timeout) {
+		return true
+	}
+	select {
+	case <-client.done:
+		// 链接已经关闭了，不算丢弃
+	default:
+		h.metrics.SendDropped("timeout")
+	}
+	return false
+}
+
+// 踢掉链接，带上原因
+func (h *ServerUnit) evict(client *Connection, reason string) {
+	client.closeWith(reason)
+	h.Unregister(client.Id)
+}
@@ -0,0 +1,166 @@
+package serverunit_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hnchenkai/mx-wsgo/serverunit"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+// 客户端不读取数据，缓冲区写满之后应该被踢掉并且走正常的关闭流程
+func TestSlowConsumerDisconnect(t *testing.T) {
+	ids := make(chan string, 1)
+	closed := make(chan string, 1)
+	unit := serverunit.NewServerUnit(func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
+		switch cmd {
+		case wsmessage.CmdAccept:
+			ids <- msg.ClientId
+		case wsmessage.CmdClose:
+			closed <- msg.ClientId
+		}
+	}, nil,
+		serverunit.WithClientOptions(serverunit.WithWriteWait(time.Minute)),
+		serverunit.WithSendBuffer(serverunit.SendBufferOptions{
+			BufferSize: 1,
+			Policy:     serverunit.SlowConsumerDisconnect,
+		}))
+	go unit.Run()
+
+	svr := httptest.NewServer(unit)
+	defer svr.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(svr.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	clientId := <-ids
+	big := bytes.Repeat([]byte{'a'}, 1<<20)
+	evicted := false
+	for i := 0; i < 256 && !evicted; i++ {
+		evicted = !unit.Send(clientId, big)
+	}
+	if !evicted {
+		t.Fatal("slow consumer was not evicted")
+	}
+
+	select {
+	case id := <-closed:
+		if id != clientId {
+			t.Fatalf("unexpected close %s", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close was not dispatched")
+	}
+}
+
+// 建立一个不读取数据的链接，返回链接id
+func slowConsumer(t *testing.T, opt serverunit.SendBufferOptions) (*serverunit.ServerUnit, string, *websocket.Conn, func()) {
+	ids := make(chan string, 1)
+	unit := serverunit.NewServerUnit(func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
+		if cmd == wsmessage.CmdAccept {
+			ids <- msg.ClientId
+		}
+	}, nil,
+		serverunit.WithClientOptions(serverunit.WithWriteWait(time.Minute)),
+		serverunit.WithSendBuffer(opt))
+	go unit.Run()
+
+	svr := httptest.NewServer(unit)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(svr.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case clientId := <-ids:
+		return unit, clientId, conn, func() {
+			conn.Close()
+			unit.Close()
+			svr.Close()
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("accept timeout")
+	}
+	return nil, "", nil, nil
+}
+
+// 阻塞模式最多等待 Timeout，广播不会为每条消息启动协程
+func TestSlowConsumerBlock(t *testing.T) {
+	unit, clientId, _, cleanup := slowConsumer(t, serverunit.SendBufferOptions{
+		BufferSize: 1,
+		Policy:     serverunit.SlowConsumerBlock,
+		Timeout:    50 * time.Millisecond,
+	})
+	defer cleanup()
+
+	big := bytes.Repeat([]byte{'a'}, 1<<20)
+	blocked := false
+	for i := 0; i < 256 && !blocked; i++ {
+		start := time.Now()
+		if !unit.Send(clientId, big) {
+			if cost := time.Since(start); cost < 50*time.Millisecond || cost > time.Second {
+				t.Fatalf("unexpected block time %v", cost)
+			}
+			blocked = true
+		}
+	}
+	if !blocked {
+		t.Fatal("send never blocked")
+	}
+
+	goroutines := runtime.NumGoroutine()
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		unit.Broadcast([]byte("broadcast"))
+	}
+	if cost := time.Since(start); cost < 500*time.Millisecond {
+		t.Fatalf("broadcast did not wait %v", cost)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines+2 {
+		t.Fatalf("goroutines grew from %d to %d", goroutines, n)
+	}
+}
+
+// 丢弃最早的消息，客户端最后收到的是最新的消息
+func TestSlowConsumerDropOldest(t *testing.T) {
+	unit, clientId, conn, cleanup := slowConsumer(t, serverunit.SendBufferOptions{
+		BufferSize: 4,
+		Policy:     serverunit.SlowConsumerDropOldest,
+	})
+	defer cleanup()
+
+	// 先发送大量数据把写协程卡住
+	big := bytes.Repeat([]byte{'a'}, 1<<20)
+	for i := 0; i < 16; i++ {
+		unit.Send(clientId, big)
+	}
+	for i := 0; i < 20; i++ {
+		if !unit.Send(clientId, []byte(fmt.Sprint("m", i))) {
+			t.Fatalf("send %d dropped", i)
+		}
+	}
+
+	var got []string
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(got) == 0 || got[len(got)-1] != "m19" {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read failed after %v: %v", got, err)
+		}
+		for _, v := range bytes.Split(data, []byte{'\n'}) {
+			if bytes.HasPrefix(v, []byte("m")) {
+				got = append(got, string(v))
+			}
+		}
+	}
+	if strings.Join(got, ",") != "m16,m17,m18,m19" {
+		t.Fatalf("unexpected messages %v", got)
+	}
+}
@@ -0,0 +1,172 @@
+package serverunit
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+// 会话配置，开启之后断开的链接在宽限期内重连可以恢复原来的链接id、排队位置和接入名额
+// 会话只保存在当前节点，重连需要回到同一个节点
+type SessionOptions struct {
+	// 签名token的密钥，为空的时候随机生成
+	Secret []byte
+	// 断开之后会话保留的时间 默认30秒
+	Grace time.Duration
+	// 重连时携带token的url参数 默认 session
+	QueryParam string
+	// 重连时携带token的请求头 默认 Mx-Wsgo-Session
+	HeaderName string
+	// 重放缓冲区保留的消息条数 默认256，小于0不保留
+	ReplaySize int
+	// 重放缓冲区保留消息的时间 默认1分钟
+	ReplayAge time.Duration
+}
+
+func (o *SessionOptions) normalize() {
+	if len(o.Secret) == 0 {
+		o.Secret = make([]byte, 32)
+		rand.Read(o.Secret)
+	}
+	if o.Grace <= 0 {
+		o.Grace = 30 * time.Second
+	}
+	if o.QueryParam == "" {
+		o.QueryParam = "session"
+	}
+	if o.HeaderName == "" {
+		o.HeaderName = wsmessage.WsSessionHeader
+	}
+	if o.ReplaySize == 0 {
+		o.ReplaySize = 256
+	}
+	if o.ReplayAge <= 0 {
+		o.ReplayAge = time.Minute
+	}
+}
+
+func (o *SessionOptions) mac(payload string) []byte {
+	m := hmac.New(sha256.New, o.Secret)
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
+
+// 生成会话token，内容是链接id和签发时间
+func (o *SessionOptions) sign(clientId string) string {
+	payload := clientId + "|" + strconv.FormatInt(time.Now().Unix(), 10)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(payload)) + "." + enc.EncodeToString(o.mac(payload))
+}
+
+// 校验token，返回链接id
+func (o *SessionOptions) verify(token string) (string, bool) {
+	enc := base64.RawURLEncoding
+	p, s, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", false
+	}
+	payload, err := enc.DecodeString(p)
+	if err != nil {
+		return "", false
+	}
+	sig, err := enc.DecodeString(s)
+	if err != nil || !hmac.Equal(sig, o.mac(string(payload))) {
+		return "", false
+	}
+	clientId, _, ok := strings.Cut(string(payload), "|")
+	return clientId, ok && clientId != ""
+}
+
+func (o *SessionOptions) tokenFrom(r *http.Request) string {
+	if token := r.Header.Get(o.HeaderName); token != "" {
+		return token
+	}
+	return r.URL.Query().Get(o.QueryParam)
+}
+
+// 重连的请求带了有效的token，返回还在宽限期内的旧链接
+func (h *ServerUnit) resumable(r *http.Request, identity string) *Connection {
+	if h.sessions == nil {
+		return nil
+	}
+	token := h.sessions.tokenFrom(r)
+	if token == "" {
+		return nil
+	}
+	clientId, ok := h.sessions.verify(token)
+	if !ok {
+		return nil
+	}
+	old, ok := h.clients.get(clientId)
+	if !ok || old.GetHeader(wsmessage.WsIdentityHeader) != identity {
+		// 会话已经过期，或者换了身份
+		return nil
+	}
+	return old
+}
+
+// 客户端断开，开启会话的时候先保留一段时间
+func (h *ServerUnit) detach(client *Connection) {
+	if h.sessions == nil {
+		h.Unregister(client.Id)
+		return
+	}
+	select {
+	case h.detachCh <- client:
+	case <-h.fclose.Done():
+	}
+}
+
+// 在 Run 里面执行，把链接标记为断开并开始计时
+func (h *ServerUnit) onDetach(client *Connection) {
+	if cur, ok := h.clients.get(client.Id); !ok || cur != client {
+		// 已经被注销或者被新的链接替换了
+		return
+	}
+	client.close()
+	atomic.StoreInt32(&client.detached, 1)
+	client.grace = time.AfterFunc(h.sessions.Grace, func() {
+		select {
+		case h.expireCh <- client:
+		case <-h.fclose.Done():
+		}
+	})
+}
+
+// 在 Run 里面执行，宽限期到了还没有重连的正式注销
+func (h *ServerUnit) onExpire(client *Connection) {
+	if cur, ok := h.clients.get(client.Id); ok && cur == client {
+		h.remove(client)
+	}
+}
+
+// 恢复会话之后按照原来的状态通知客户端，不再重新判断限流
+func (h *ServerUnit) restore(client *Connection) {
+	if !h.takeover(client) {
+		return
+	}
+	msg := h.GetConnMessage(client.Id)
+	if msg == nil {
+		return
+	}
+	switch client.GetHeader(wsmessage.WsStatusHeader) {
+	case string(wsmessage.LimitAccept):
+		msg.SendResponseCmd(bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_ACCEPT, []byte("连接成功"), nil)
+	case string(wsmessage.LimitWait):
+		msg.SendWaitInfo(h.WaitInfo(msg.Context(), msg.Group(), client.Id))
+	default:
+		// 断开之前还没有判断过状态
+		h.accept(client)
+		return
+	}
+	h.doDispatch(wsmessage.CmdResume, msg)
+}
@@ -0,0 +1,273 @@
+package serverunit_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
+	"github.com/hnchenkai/mx-wsgo/limitcount"
+	"github.com/hnchenkai/mx-wsgo/serverunit"
+	"github.com/hnchenkai/mx-wsgo/wsclient"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+	"google.golang.org/protobuf/proto"
+)
+
+// 读取命令直到拿到会话信息和接入/排队结果
+func readSession(t *testing.T, conn *websocket.Conn) (*bytecoder.MessageSessionInfo, bytecoder.MsgLocalCmd) {
+	var info *bytecoder.MessageSessionInfo
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, frame := range bytes.Split(data, []byte{'\n'}) {
+			coder := bytecoder.StreamCoder(frame)
+			coder.DecodeWS()
+			coder.UnGzip()
+			msg, err := coder.UnmarshalCmd()
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch msg.GetCmd() {
+			case bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_SESSION:
+				info = &bytecoder.MessageSessionInfo{}
+				proto.Unmarshal(msg.GetBody(), info)
+			case bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_ACCEPT, bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_WAIT:
+				if info == nil {
+					t.Fatal("status before session")
+				}
+				return info, msg.GetCmd()
+			}
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSessionResume(t *testing.T) {
+	closed := make(chan string, 4)
+	unit := serverunit.NewServerUnit(func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
+		if cmd == wsmessage.CmdClose {
+			closed <- msg.ClientId
+		}
+	}, &limitcount.LimitOption{
+		ReadyLimitFunc: func(limitkey string) int {
+			return 1
+		},
+		WaitLimitFunc: func(limitkey string) int {
+			return 5
+		},
+	}, serverunit.WithSessions(serverunit.SessionOptions{Grace: 300 * time.Millisecond}))
+	go unit.Run()
+	defer unit.Close()
+	svr := httptest.NewServer(unit)
+	defer svr.Close()
+	url := "ws" + strings.TrimPrefix(svr.URL, "http")
+	header := http.Header{}
+	header.Set(wsmessage.WsGroupHeader, "test")
+
+	dial := func() (*websocket.Conn, *bytecoder.MessageSessionInfo, bytecoder.MsgLocalCmd) {
+		conn, _, err := websocket.DefaultDialer.Dial(url, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, cmd := readSession(t, conn)
+		return conn, info, cmd
+	}
+	first, firstInfo, cmd := dial()
+	if cmd != bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_ACCEPT || firstInfo.GetResumed() {
+		t.Fatalf("unexpected first %v %v", cmd, firstInfo)
+	}
+	defer first.Close()
+	second, info, cmd := dial()
+	if cmd != bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_WAIT {
+		t.Fatalf("unexpected second %v", cmd)
+	}
+	third, _, _ := dial()
+	defer third.Close()
+
+	// 排在第一位的链接掉线
+	second.Close()
+	waitFor(t, func() bool {
+		c, ok := unit.Connection(info.GetClientId())
+		return ok && c.Detached
+	})
+	if queue := unit.WaitQueues()["test"]; len(queue) != 2 || queue[0] != info.GetClientId() {
+		t.Fatalf("detached client lost its position %v", queue)
+	}
+
+	// 带着token重连，恢复原来的id和位置
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	sessions := make(chan *bytecoder.MessageSessionInfo, 1)
+	positions := make(chan int64, 1)
+	client, err := wsclient.Dial(ctx, url, &wsclient.Options{
+		Header: http.Header{wsmessage.WsSessionHeader: []string{info.GetToken()}},
+		OnSession: func(info *bytecoder.MessageSessionInfo) {
+			sessions <- info
+		},
+		OnWait: func(self, total int64) {
+			positions <- self
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	resumed := <-sessions
+	if !resumed.GetResumed() || resumed.GetClientId() != info.GetClientId() {
+		t.Fatalf("unexpected session %v", resumed)
+	}
+	if self := <-positions; self != 0 {
+		t.Fatalf("unexpected position %d", self)
+	}
+	if client.Session() == "" {
+		t.Fatal("client did not keep the session token")
+	}
+	select {
+	case id := <-closed:
+		t.Fatalf("unexpected close of %s", id)
+	default:
+	}
+
+	// 接入的链接掉线后超过宽限期，正式注销并释放名额
+	first.Close()
+	select {
+	case id := <-closed:
+		if id != firstInfo.GetClientId() {
+			t.Fatalf("unexpected close of %s", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("detached session did not expire")
+	}
+	if _, ok := unit.Connection(firstInfo.GetClientId()); ok {
+		t.Fatal("expired session still registered")
+	}
+
+	// 过期的token按新链接处理
+	header.Set(wsmessage.WsSessionHeader, firstInfo.GetToken())
+	conn, next, _ := dial()
+	defer conn.Close()
+	if next.GetResumed() || next.GetClientId() == firstInfo.GetClientId() {
+		t.Fatalf("expired token resumed %v", next)
+	}
+}
+
+// 读取n条消息，命令解码成名字，其他的原样返回，同时返回最后一次的会话信息
+func readFrames(t *testing.T, conn *websocket.Conn, n int) ([]string, *bytecoder.MessageSessionInfo) {
+	var out []string
+	var info *bytecoder.MessageSessionInfo
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(out) < n {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, frame := range bytes.Split(data, []byte{'\n'}) {
+			coder := bytecoder.StreamCoder(append([]byte(nil), frame...))
+			coder.DecodeWS()
+			if coder.UnGzip() != nil {
+				out = append(out, string(frame))
+				continue
+			}
+			msg, err := coder.UnmarshalCmd()
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch msg.GetCmd() {
+			case bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_SESSION:
+				info = &bytecoder.MessageSessionInfo{}
+				proto.Unmarshal(msg.GetBody(), info)
+				out = append(out, "session:"+strconv.FormatInt(info.GetSeq(), 10))
+			case bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_RESYNC:
+				out = append(out, "resync")
+			case bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_ACCEPT:
+				out = append(out, "accept")
+			default:
+				out = append(out, msg.GetCmd().String())
+			}
+		}
+	}
+	return out, info
+}
+
+func TestSessionReplay(t *testing.T) {
+	unit := serverunit.NewServerUnit(func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {},
+		nil, serverunit.WithSessions(serverunit.SessionOptions{ReplaySize: 2}))
+	go unit.Run()
+	defer unit.Close()
+	svr := httptest.NewServer(unit)
+	defer svr.Close()
+	url := "ws" + strings.TrimPrefix(svr.URL, "http")
+
+	var info *bytecoder.MessageSessionInfo
+	dial := func(seq string, n int) (*websocket.Conn, string) {
+		header := http.Header{}
+		if info != nil {
+			header.Set(wsmessage.WsSessionHeader, info.GetToken())
+			header.Set(wsmessage.WsSessionSeqHeader, seq)
+		}
+		conn, _, err := websocket.DefaultDialer.Dial(url, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames, next := readFrames(t, conn, n)
+		info = next
+		return conn, strings.Join(frames, ",")
+	}
+	detach := func(conn *websocket.Conn) {
+		conn.Close()
+		waitFor(t, func() bool {
+			c, ok := unit.Connection(info.GetClientId())
+			return ok && c.Detached
+		})
+	}
+
+	conn, frames := dial("", 2)
+	if frames != "session:0,accept" {
+		t.Fatalf("unexpected frames %s", frames)
+	}
+	if !unit.Send(info.GetClientId(), []byte("a")) {
+		t.Fatal("send failed")
+	}
+	if frames, _ := readFrames(t, conn, 1); frames[0] != "a" {
+		t.Fatalf("unexpected frames %v", frames)
+	}
+	detach(conn)
+
+	// 断开期间的消息在重连之后先重放，然后才是接入通知
+	if !unit.Send(info.GetClientId(), []byte("b")) || !unit.Send(info.GetClientId(), []byte("c")) {
+		t.Fatal("send to detached session failed")
+	}
+	conn, frames = dial("2", 4)
+	if frames != "session:2,b,c,accept" || !info.GetResumed() {
+		t.Fatalf("unexpected frames %s", frames)
+	}
+	detach(conn)
+
+	// 缺失的消息超出了缓冲区，先通知重新同步
+	for _, v := range []string{"d", "e", "f"} {
+		unit.Send(info.GetClientId(), []byte(v))
+	}
+	conn, frames = dial("5", 3)
+	defer conn.Close()
+	if frames != "session:8,resync,accept" {
+		t.Fatalf("unexpected frames %s", frames)
+	}
+}
@@ -0,0 +1,91 @@
+package serverunit_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hnchenkai/mx-wsgo/router"
+	"github.com/hnchenkai/mx-wsgo/serverunit"
+	"github.com/hnchenkai/mx-wsgo/tracing"
+	"github.com/hnchenkai/mx-wsgo/wsclient"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+func TestTracing(t *testing.T) {
+	const traceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	recorder := tracing.NewRecorder()
+	r := router.New()
+	handled := make(chan string, 1)
+	r.Handle("", "/trace", func(ctx context.Context, msg *wsmessage.WSMessage) (int32, []byte, map[string]string, error) {
+		span := tracing.SpanFromContext(ctx)
+		if span == nil {
+			return 500, nil, nil, nil
+		}
+		handled <- msg.ClientId
+		return 200, []byte(span.TraceId), nil, nil
+	})
+	unit := serverunit.NewServerUnit(r.Dispatch, nil, serverunit.WithTracer(recorder))
+	go unit.Run()
+	defer unit.Close()
+	svr := httptest.NewServer(unit)
+	defer svr.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := wsclient.Dial(ctx, "ws"+strings.TrimPrefix(svr.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.WaitAccept(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Request(ctx, "GET", "/trace", nil, map[string]string{
+		"traceparent": "00-" + traceId + "-00f067aa0ba902b7-01",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.GetMessage()) != traceId {
+		t.Fatalf("handler got trace %q", resp.GetMessage())
+	}
+	span := tracing.ParseTraceParent(resp.GetHeader()["traceparent"])
+	if span == nil || span.TraceId != traceId || span.SpanId == "00f067aa0ba902b7" {
+		t.Fatalf("unexpected response traceparent %q", resp.GetHeader()["traceparent"])
+	}
+
+	clientId := <-handled
+	// 分发结束在应答发送之后
+	deadline := time.Now().Add(time.Second)
+	var stages []string
+	for time.Now().Before(deadline) {
+		stages = stages[:0]
+		for _, ev := range recorder.Request(clientId, resp.GetRequestId()) {
+			if ev.TraceId != traceId || ev.Route != "/trace" {
+				t.Fatalf("unexpected event %+v", ev)
+			}
+			stages = append(stages, string(ev.Stage))
+		}
+		if len(stages) == 4 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if strings.Join(stages, ",") != "decode,dispatch_start,response,dispatch_end" {
+		t.Fatalf("unexpected stages %v", stages)
+	}
+
+	received := false
+	for _, ev := range recorder.Events() {
+		if ev.Stage == tracing.StageReceive && ev.ClientId == clientId && ev.Size > 0 {
+			received = true
+		}
+	}
+	if !received {
+		t.Fatal("missing receive event")
+	}
+}
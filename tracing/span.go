@@ -0,0 +1,99 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// W3C trace context 的请求头
+const TraceParentHeader = "traceparent"
+
+// 一次请求的追踪信息
+type Span struct {
+	TraceId  string
+	SpanId   string
+	ParentId string
+	Flags    string
+	// 是否从客户端的 traceparent 延续下来
+	Remote bool
+}
+
+// TraceParent 生成 traceparent 头的值
+func (s *Span) TraceParent() string {
+	return "00-" + s.TraceId + "-" + s.SpanId + "-" + s.Flags
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n || strings.Trim(s, "0") == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseTraceParent 解析 traceparent，格式不正确返回nil
+func ParseTraceParent(v string) *Span {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return nil
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return nil
+	}
+	if !isHex(parts[1], 32) || !isHex(parts[2], 16) || len(parts[3]) != 2 {
+		return nil
+	}
+	return &Span{TraceId: parts[1], SpanId: parts[2], Flags: parts[3], Remote: true}
+}
+
+// NewSpan 生成一个新的span，parent 为空的时候开始一个新的trace
+func NewSpan(parent *Span) *Span {
+	if parent == nil {
+		return &Span{TraceId: randomHex(16), SpanId: randomHex(8), Flags: "01"}
+	}
+	return &Span{
+		TraceId:  parent.TraceId,
+		SpanId:   randomHex(8),
+		ParentId: parent.SpanId,
+		Flags:    parent.Flags,
+		Remote:   parent.Remote,
+	}
+}
+
+// FromHeader 从请求头中读取 traceparent 生成子span，请求头的key不区分大小写
+func FromHeader(header map[string]string) *Span {
+	for k, v := range header {
+		if strings.EqualFold(k, TraceParentHeader) {
+			return NewSpan(ParseTraceParent(v))
+		}
+	}
+	return NewSpan(nil)
+}
+
+type spanKey struct{}
+
+// ContextWithSpan 把span放到context里面
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext 获取context中的span，没有返回nil
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
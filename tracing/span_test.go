@@ -0,0 +1,41 @@
+package tracing_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hnchenkai/mx-wsgo/tracing"
+)
+
+func TestTraceParent(t *testing.T) {
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	span := tracing.FromHeader(map[string]string{"Traceparent": parent})
+	if !span.Remote || span.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || span.ParentId != "00f067aa0ba902b7" {
+		t.Fatalf("unexpected span %+v", span)
+	}
+	if span.SpanId == span.ParentId || tracing.ParseTraceParent(span.TraceParent()) == nil {
+		t.Fatalf("invalid child span %s", span.TraceParent())
+	}
+
+	for _, v := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+	} {
+		if tracing.ParseTraceParent(v) != nil {
+			t.Fatalf("expected invalid %q", v)
+		}
+	}
+
+	// 没有 traceparent 开始新的trace
+	root := tracing.FromHeader(nil)
+	if root.Remote || tracing.ParseTraceParent(root.TraceParent()) == nil {
+		t.Fatalf("unexpected root span %+v", root)
+	}
+	ctx := tracing.ContextWithSpan(context.Background(), root)
+	if tracing.SpanFromContext(ctx) != root || tracing.SpanFromContext(context.Background()) != nil {
+		t.Fatal("span not found in context")
+	}
+}
@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 请求经过的阶段
+type Stage string
+
+const (
+	StageReceive       Stage = "receive"        // 收到客户端的帧
+	StageDecode        Stage = "decode"         // FromPb 解码完成
+	StageDispatchStart Stage = "dispatch_start" // 开始分发
+	StageDispatchEnd   Stage = "dispatch_end"   // 分发结束
+	StageResponse      Stage = "response"       // 发送应答
+)
+
+// 追踪事件
+type Event struct {
+	Stage    Stage
+	Time     time.Time
+	ClientId string
+	Group    string
+	ReqId    int64
+	Route    string
+	// 分发的类型，只有分发阶段有
+	Cmd string
+	// 追踪信息，解码之后才有
+	TraceId string
+	SpanId  string
+	// 帧的大小，只有收到帧的阶段有
+	Size int
+	// 应答码，只有应答阶段有
+	Code int32
+	// 分发的耗时，只有分发结束有
+	Duration time.Duration
+	Err      error
+}
+
+// 追踪的钩子，在请求经过的每个阶段调用，需要并发安全
+type Tracer interface {
+	Trace(ctx context.Context, ev Event)
+}
+
+type TracerFunc func(ctx context.Context, ev Event)
+
+func (f TracerFunc) Trace(ctx context.Context, ev Event) {
+	f(ctx, ev)
+}
+
+type nopTracer struct{}
+
+func (nopTracer) Trace(ctx context.Context, ev Event) {}
+
+// Nop 不做任何事情的钩子
+func Nop() Tracer {
+	return nopTracer{}
+}
+
+// Emit 补全时间和追踪信息后调用钩子，t 为空的时候什么都不做
+func Emit(t Tracer, ctx context.Context, ev Event) {
+	if t == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if span := SpanFromContext(ctx); span != nil {
+		ev.TraceId = span.TraceId
+		ev.SpanId = span.SpanId
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	t.Trace(ctx, ev)
+}
+
+// 记录所有事件，给测试使用
+type Recorder struct {
+	lock   sync.Mutex
+	events []Event
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) Trace(ctx context.Context, ev Event) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, ev)
+}
+
+// Events 所有事件的拷贝
+func (r *Recorder) Events() []Event {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]Event{}, r.events...)
+}
+
+// Request 某个链接的某个请求经过的事件
+func (r *Recorder) Request(clientId string, reqId int64) []Event {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var events []Event
+	for _, ev := range r.events {
+		if ev.ClientId == clientId && ev.ReqId == reqId {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+func (r *Recorder) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = nil
+}
@@ -0,0 +1,527 @@
+package wsclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	ErrClosed       = errors.New("client closed")
+	ErrDisconnected = errors.New("connection lost")
+	ErrNotConnected = errors.New("not connected")
+)
+
+// 链接状态
+type Status int32
+
+const (
+	StatusConnecting Status = iota // 正在建立链接
+	StatusConnected                // 已经建立链接，等待服务端的接入结果
+	StatusWaiting                  // 排队中
+	StatusAccepted                 // 已经接入
+	StatusClosed                   // 已经关闭
+)
+
+type Options struct {
+	// 建立链接时携带的header，Mx-Ws- 开头的会被转发到服务端链接的header中
+	Header http.Header
+	// 分组
+	Group string
+
+	// 接入成功
+	OnAccept func()
+	// 排队中，self 前面的人数，total 排队总人数
+	OnWait func(self int64, total int64)
+	// 排队中，带上预计的等待时间和接入速度
+	OnWaitInfo func(info *bytecoder.MessageWaitInfo)
+	// 服务端发起断开
+	OnClose func(reason string)
+	// 收到的没有对应请求的应答
+	OnMessage func(msg *bytecoder.Messagev0)
+	// 收到的其他命令
+	OnCmd func(msg *bytecoder.MessageCMD)
+	// 链接断开，err 为断开的原因
+	OnDisconnect func(err error)
+	// 收到服务端下发的会话，重连的时候会带上token恢复会话
+	OnSession func(info *bytecoder.MessageSessionInfo)
+	// 重连时断开期间的消息已经超出服务端的重放缓冲区，有消息丢失，需要重新同步业务状态
+	OnResync func()
+
+	// 断线后是否自动重连
+	Reconnect bool
+	// 重连的最小间隔 默认500毫秒
+	MinBackoff time.Duration
+	// 重连的最大间隔 默认30秒
+	MaxBackoff time.Duration
+
+	// 为空使用 websocket.DefaultDialer
+	Dialer *websocket.Dialer
+}
+
+func (o *Options) init() {
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = 500 * time.Millisecond
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 30 * time.Second
+	}
+	if o.MaxBackoff < o.MinBackoff {
+		o.MaxBackoff = o.MinBackoff
+	}
+	if o.Dialer == nil {
+		o.Dialer = websocket.DefaultDialer
+	}
+}
+
+type result struct {
+	resp *bytecoder.Messagev0
+	cmd  *bytecoder.MessageCMD
+	err  error
+}
+
+// mx-wsgo 协议的客户端
+type Client struct {
+	url  string
+	opts Options
+
+	conn      *websocket.Conn
+	connLock  sync.RWMutex
+	writeLock sync.Mutex
+
+	reqId   int64
+	pending map[int64]chan result
+	lock    sync.Mutex
+
+	// 服务端下发的会话token，以及已经收到的最后一条消息的序号
+	session string
+	seq     int64
+
+	status   int32
+	accepted chan struct{}
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// Dial 建立链接，开启自动重连的时候后续断线会在后台重连
+func Dial(ctx context.Context, url string, opts *Options) (*Client, error) {
+	c := &Client{
+		url:      url,
+		pending:  make(map[int64]chan result),
+		accepted: make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	if opts != nil {
+		c.opts = *opts
+	}
+	c.opts.init()
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.setConn(conn)
+	go c.run(conn)
+	return c, nil
+}
+
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	header := http.Header{}
+	for k, v := range c.opts.Header {
+		header[k] = v
+	}
+	if c.opts.Group != "" {
+		header.Set(wsmessage.WsGroupHeader, c.opts.Group)
+	}
+	c.lock.Lock()
+	if c.session != "" {
+		header.Set(wsmessage.WsSessionHeader, c.session)
+		header.Set(wsmessage.WsSessionSeqHeader, strconv.FormatInt(c.seq, 10))
+	}
+	c.lock.Unlock()
+	c.setStatus(StatusConnecting)
+	conn, _, err := c.opts.Dialer.DialContext(ctx, c.url, header)
+	if err != nil {
+		return nil, err
+	}
+	c.setStatus(StatusConnected)
+	return conn, nil
+}
+
+func (c *Client) setConn(conn *websocket.Conn) {
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+	c.conn = conn
+}
+
+func (c *Client) getConn() *websocket.Conn {
+	c.connLock.RLock()
+	defer c.connLock.RUnlock()
+	return c.conn
+}
+
+func (c *Client) setStatus(status Status) {
+	atomic.StoreInt32(&c.status, int32(status))
+}
+
+// Session 服务端下发的会话token，没有开启会话的时候为空
+func (c *Client) Session() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.session
+}
+
+// 当前的链接状态
+func (c *Client) Status() Status {
+	return Status(atomic.LoadInt32(&c.status))
+}
+
+// 读取消息，断开之后按需重连
+func (c *Client) run(conn *websocket.Conn) {
+	for {
+		err := c.readLoop(conn)
+		c.failPending(ErrDisconnected)
+		if c.isClosed() {
+			return
+		}
+		if c.opts.OnDisconnect != nil {
+			c.opts.OnDisconnect(err)
+		}
+		if !c.opts.Reconnect {
+			c.shutdown()
+			return
+		}
+		if conn = c.reconnect(); conn == nil {
+			return
+		}
+	}
+}
+
+// 指数退避重连，返回nil表示客户端已经关闭
+func (c *Client) reconnect() *websocket.Conn {
+	backoff := c.opts.MinBackoff
+	for {
+		// 加一点随机，避免大量客户端同时重连
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-time.After(delay):
+		case <-c.closed:
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), c.opts.MaxBackoff)
+		conn, err := c.dial(ctx)
+		cancel()
+		if err == nil {
+			c.setConn(conn)
+			if c.isClosed() {
+				conn.Close()
+				return nil
+			}
+			return conn
+		}
+
+		backoff *= 2
+		if backoff > c.opts.MaxBackoff {
+			backoff = c.opts.MaxBackoff
+		}
+	}
+}
+
+func (c *Client) readLoop(conn *websocket.Conn) error {
+	defer conn.Close()
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		// 服务端会把多条消息用换行合并到一个帧里面
+		for _, frame := range bytes.Split(data, []byte{'\n'}) {
+			if len(frame) > 0 {
+				c.handleFrame(frame)
+			}
+		}
+	}
+}
+
+func (c *Client) handleFrame(frame []byte) {
+	coder := bytecoder.StreamCoder(frame)
+	coder.DecodeWS()
+	if err := coder.UnGzip(); err != nil {
+		c.count()
+		return
+	}
+
+	if coder.Version() == bytecoder.Version_VERSION_CMD {
+		msg, err := coder.UnmarshalCmd()
+		if err != nil {
+			c.count()
+			return
+		}
+		switch msg.GetCmd() {
+		case bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_SESSION, bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_RESYNC:
+		default:
+			c.count()
+		}
+		c.handleCmd(msg)
+		return
+	}
+
+	c.count()
+	msg, err := coder.UnmarshalV0()
+	if err != nil {
+		return
+	}
+	if !c.resolve(msg.GetRequestId(), result{resp: msg}) && c.opts.OnMessage != nil {
+		c.opts.OnMessage(msg)
+	}
+}
+
+func (c *Client) handleCmd(msg *bytecoder.MessageCMD) {
+	switch msg.GetCmd() {
+	case bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_ACCEPT:
+		c.setStatus(StatusAccepted)
+		c.lock.Lock()
+		select {
+		case <-c.accepted:
+		default:
+			close(c.accepted)
+		}
+		c.lock.Unlock()
+		if c.opts.OnAccept != nil {
+			c.opts.OnAccept()
+		}
+	case bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_WAIT:
+		c.setStatus(StatusWaiting)
+		c.onWait(msg.GetBody())
+	case bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_RESP:
+		// 主动查询的结果交给请求方，其他的当作排队通知
+		if !c.resolve(msg.GetRequestId(), result{cmd: msg}) {
+			c.onWait(msg.GetBody())
+		}
+	case bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_SESSION:
+		info := &bytecoder.MessageSessionInfo{}
+		if err := proto.Unmarshal(msg.GetBody(), info); err != nil {
+			return
+		}
+		c.lock.Lock()
+		c.session = info.GetToken()
+		c.seq = info.GetSeq()
+		c.lock.Unlock()
+		if c.opts.OnSession != nil {
+			c.opts.OnSession(info)
+		}
+	case bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_RESYNC:
+		if c.opts.OnResync != nil {
+			c.opts.OnResync()
+		}
+	case bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_CLOSE:
+		if c.opts.OnClose != nil {
+			c.opts.OnClose(string(msg.GetBody()))
+		}
+	default:
+		if !c.resolve(msg.GetRequestId(), result{cmd: msg}) && c.opts.OnCmd != nil {
+			c.opts.OnCmd(msg)
+		}
+	}
+}
+
+// 会话和重新同步命令之外的消息都计数，重连的时候服务端从下一条开始重放
+func (c *Client) count() {
+	c.lock.Lock()
+	c.seq++
+	c.lock.Unlock()
+}
+
+func (c *Client) onWait(body []byte) {
+	info := bytecoder.MessageWaitInfo{}
+	if err := proto.Unmarshal(body, &info); err != nil {
+		return
+	}
+	if c.opts.OnWait != nil {
+		c.opts.OnWait(info.GetSelf(), info.GetTotal())
+	}
+	if c.opts.OnWaitInfo != nil {
+		c.opts.OnWaitInfo(&info)
+	}
+}
+
+// 把应答交给等待的请求，没有等待的请求返回false
+func (c *Client) resolve(reqId int64, res result) bool {
+	if reqId == 0 {
+		return false
+	}
+	c.lock.Lock()
+	ch, ok := c.pending[reqId]
+	delete(c.pending, reqId)
+	c.lock.Unlock()
+	if ok {
+		ch <- res
+	}
+	return ok
+}
+
+func (c *Client) failPending(err error) {
+	c.lock.Lock()
+	pending := c.pending
+	c.pending = make(map[int64]chan result)
+	// 断线之后需要重新等待接入
+	select {
+	case <-c.accepted:
+		c.accepted = make(chan struct{})
+	default:
+	}
+	c.lock.Unlock()
+	for _, ch := range pending {
+		ch <- result{err: err}
+	}
+}
+
+func (c *Client) write(frame bytecoder.StreamCoder) error {
+	conn := c.getConn()
+	if conn == nil || c.isClosed() {
+		return ErrNotConnected
+	}
+	frame.Gzip()
+	frame.EncodeWS()
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return conn.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+// 发送请求并等待对应 request_id 的应答
+func (c *Client) roundTrip(ctx context.Context, build func(reqId int64) bytecoder.StreamCoder) (result, error) {
+	reqId := atomic.AddInt64(&c.reqId, 1)
+	ch := make(chan result, 1)
+	c.lock.Lock()
+	c.pending[reqId] = ch
+	c.lock.Unlock()
+
+	if err := c.write(build(reqId)); err != nil {
+		c.lock.Lock()
+		delete(c.pending, reqId)
+		c.lock.Unlock()
+		return result{}, err
+	}
+
+	select {
+	case res := <-ch:
+		return res, res.err
+	case <-ctx.Done():
+		c.lock.Lock()
+		delete(c.pending, reqId)
+		c.lock.Unlock()
+		return result{}, ctx.Err()
+	case <-c.closed:
+		return result{}, ErrClosed
+	}
+}
+
+// Request 发送 Messagev1 请求并等待应答
+func (c *Client) Request(ctx context.Context, method string, route string, body []byte, header map[string]string) (*bytecoder.Messagev0, error) {
+	return c.RequestWithOptions(ctx, method, route, body, header, false)
+}
+
+// RequestWithOptions 发送 Messagev1 请求，responseHeader 表示需要服务端返回应答头
+func (c *Client) RequestWithOptions(ctx context.Context, method string, route string, body []byte, header map[string]string, responseHeader bool) (*bytecoder.Messagev0, error) {
+	res, err := c.roundTrip(ctx, func(reqId int64) bytecoder.StreamCoder {
+		return bytecoder.MarshalV1(reqId, method, route, body, header, responseHeader)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.resp, nil
+}
+
+// RequestCmd 发送 Messagev2 请求并等待应答
+func (c *Client) RequestCmd(ctx context.Context, cmd int32, body []byte, header map[string]string) (*bytecoder.Messagev0, error) {
+	res, err := c.roundTrip(ctx, func(reqId int64) bytecoder.StreamCoder {
+		return bytecoder.MarshalV2(reqId, cmd, body, header)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.resp, nil
+}
+
+// QueryWait 主动查询排队信息
+func (c *Client) QueryWait(ctx context.Context) (*bytecoder.MessageWaitInfo, error) {
+	res, err := c.roundTrip(ctx, func(reqId int64) bytecoder.StreamCoder {
+		return bytecoder.MarshalCMD(reqId, bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_REQ, nil, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.cmd == nil {
+		return nil, errors.New("unexpected response")
+	}
+	info := &bytecoder.MessageWaitInfo{}
+	if err := proto.Unmarshal(res.cmd.GetBody(), info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// WaitAccept 等待服务端接入，排队中的会一直等到被接入
+func (c *Client) WaitAccept(ctx context.Context) error {
+	for {
+		c.lock.Lock()
+		accepted := c.accepted
+		c.lock.Unlock()
+		select {
+		case <-accepted:
+			if c.Status() == StatusAccepted {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.closed:
+			return ErrClosed
+		}
+	}
+}
+
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Client) shutdown() {
+	c.once.Do(func() {
+		c.setStatus(StatusClosed)
+		close(c.closed)
+	})
+}
+
+// Close 关闭客户端，不再重连
+func (c *Client) Close() error {
+	c.shutdown()
+	conn := c.getConn()
+	if conn == nil {
+		return nil
+	}
+	c.writeLock.Lock()
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.writeLock.Unlock()
+	return conn.Close()
+}
+
+// Done 客户端关闭之后返回
+func (c *Client) Done() <-chan struct{} {
+	return c.closed
+}
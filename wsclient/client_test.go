@@ -0,0 +1,138 @@
+package wsclient_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hnchenkai/mx-wsgo/limitcount"
+	"github.com/hnchenkai/mx-wsgo/router"
+	"github.com/hnchenkai/mx-wsgo/serverunit"
+	"github.com/hnchenkai/mx-wsgo/wsclient"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+func newServer(t *testing.T, accepted chan string) (*serverunit.ServerUnit, string) {
+	r := router.New()
+	r.OnEvent(func(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
+		if cmd == wsmessage.CmdAccept && accepted != nil {
+			accepted <- msg.ClientId
+		}
+	})
+	r.Handle("POST", "/echo", func(ctx context.Context, msg *wsmessage.WSMessage) (int32, []byte, map[string]string, error) {
+		return 200, msg.Message, nil, nil
+	})
+	unit := serverunit.NewServerUnit(r.Dispatch, &limitcount.LimitOption{
+		ReadyLimitFunc: func(limitkey string) int {
+			return 1
+		},
+		WaitLimitFunc: func(limitkey string) int {
+			return 10
+		},
+	})
+	go unit.Run()
+	svr := httptest.NewServer(unit)
+	t.Cleanup(func() {
+		svr.Close()
+		unit.Close()
+	})
+	return unit, "ws" + strings.TrimPrefix(svr.URL, "http")
+}
+
+func TestRequestAndWait(t *testing.T) {
+	_, url := newServer(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first, err := wsclient.Dial(ctx, url, &wsclient.Options{Group: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if err := first.WaitAccept(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := first.Request(ctx, "POST", "/echo", []byte("hello"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetCode() != 200 || string(resp.GetMessage()) != "hello" {
+		t.Fatalf("unexpected response %d %q", resp.GetCode(), resp.GetMessage())
+	}
+
+	waited := make(chan int64, 8)
+	second, err := wsclient.Dial(ctx, url, &wsclient.Options{
+		Group: "test",
+		OnWait: func(self, total int64) {
+			waited <- total
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	select {
+	case <-waited:
+	case <-ctx.Done():
+		t.Fatal("no wait notification")
+	}
+	if second.Status() != wsclient.StatusWaiting {
+		t.Fatalf("unexpected status %d", second.Status())
+	}
+	info, err := second.QueryWait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.GetTotal() < 1 {
+		t.Fatalf("unexpected wait info %v", info)
+	}
+
+	// 第一个离开之后第二个会在下一次分配的时候被接入
+	first.Close()
+	acceptCtx, acceptCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer acceptCancel()
+	if err := second.WaitAccept(acceptCtx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	ids := make(chan string, 2)
+	unit, url := newServer(t, ids)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var accepts int32
+	accepted := make(chan struct{}, 2)
+	client, err := wsclient.Dial(ctx, url, &wsclient.Options{
+		Group:      "test",
+		Reconnect:  true,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 50 * time.Millisecond,
+		OnAccept: func() {
+			atomic.AddInt32(&accepts, 1)
+			accepted <- struct{}{}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	<-accepted
+
+	// 服务端主动断开
+	unit.Unregister(<-ids)
+	select {
+	case <-accepted:
+	case <-ctx.Done():
+		t.Fatal("client did not reconnect")
+	}
+	if atomic.LoadInt32(&accepts) != 2 {
+		t.Fatalf("unexpected accepts %d", accepts)
+	}
+}
@@ -0,0 +1,119 @@
+package wsmessage
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hnchenkai/mx-wsgo/tracing"
+)
+
+const (
+	WsRequestIdHeader = PrefixLocalHeader + "Request-Id"
+	WsDurationHeader  = PrefixLocalHeader + "Duration"
+	WsNodeHeader      = PrefixLocalHeader + "Node"
+)
+
+// 默认不返回给客户端的头
+var DefaultDenyHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// 客户端设置了 response_header 的时候，应答头的生成规则
+type HeaderPolicy struct {
+	// 不为空的时候只返回这里面的链接头
+	Allow []string
+	// 不返回的头，在 DefaultDenyHeaders 的基础上追加
+	Deny []string
+	// DefaultDenyHeaders 里面仍然需要返回的头，需要明确列出来
+	Expose []string
+	// 节点标识，不为空的时候返回 Mx-Wsgo-Node
+	NodeId string
+}
+
+var defaultHeaderPolicy = &HeaderPolicy{}
+
+func headerIn(list []string, key string) bool {
+	for _, v := range list {
+		if http.CanonicalHeaderKey(v) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *HeaderPolicy) deny(key string) bool {
+	if headerIn(p.Deny, key) {
+		return true
+	}
+	return headerIn(DefaultDenyHeaders, key) && !headerIn(p.Expose, key)
+}
+
+// 链接头是否允许返回，内部使用的头只返回分组
+func (p *HeaderPolicy) allowConn(key string) bool {
+	if p.deny(key) {
+		return false
+	}
+	if len(p.Allow) > 0 {
+		return headerIn(p.Allow, key)
+	}
+	return key == WsGroupHeader || !strings.HasPrefix(key, PrefixLocalHeader)
+}
+
+// 生成应答头，优先级 处理函数返回的 > 服务端生成的 > 请求头 > 链接头
+func (app *WSMessage) responseHeader(header map[string]string) map[string]string {
+	if !app.ResponseHeader {
+		return app.traceHeader(header)
+	}
+	policy := app.HeaderPolicy
+	if policy == nil {
+		policy = defaultHeaderPolicy
+	}
+
+	hd := map[string]string{}
+	for k, v := range app.OrgHeader {
+		k = http.CanonicalHeaderKey(k)
+		if len(v) > 0 && policy.allowConn(k) {
+			hd[k] = v[0]
+		}
+	}
+	for k, v := range app.Header {
+		if !policy.deny(http.CanonicalHeaderKey(k)) {
+			hd[k] = v
+		}
+	}
+
+	hd[WsRequestIdHeader] = strconv.FormatInt(app.ReqId, 10)
+	if !app.ReceivedAt.IsZero() {
+		hd[WsDurationHeader] = strconv.FormatInt(time.Since(app.ReceivedAt).Microseconds(), 10) + "us"
+	}
+	if policy.NodeId != "" {
+		hd[WsNodeHeader] = policy.NodeId
+	}
+
+	for k, v := range header {
+		hd[k] = v
+	}
+	return app.traceHeader(hd)
+}
+
+// 客户端带了 traceparent 的时候，应答带上服务端的 traceparent
+func (app *WSMessage) traceHeader(header map[string]string) map[string]string {
+	span := tracing.SpanFromContext(app.Context())
+	if span == nil || !span.Remote {
+		return header
+	}
+	// 回显的请求头里面是客户端自己的 traceparent，需要替换掉
+	hd := make(map[string]string, len(header)+1)
+	for k, v := range header {
+		if !strings.EqualFold(k, tracing.TraceParentHeader) {
+			hd[k] = v
+		}
+	}
+	hd[tracing.TraceParentHeader] = span.TraceParent()
+	return hd
+}
@@ -0,0 +1,121 @@
+package wsmessage_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+func response(t *testing.T, policy *wsmessage.HeaderPolicy, responseHeader bool) map[string]string {
+	var sent []byte
+	msg := &wsmessage.WSMessage{
+		OrgHeader: http.Header{
+			"User-Id":                  []string{"u1"},
+			"Authorization":            []string{"secret"},
+			"Secret-Token":             []string{"secret"},
+			wsmessage.WsGroupHeader:    []string{"vip"},
+			wsmessage.WsIdentityHeader: []string{"id1"},
+		},
+		HeaderPolicy: policy,
+		Send: func(message []byte) bool {
+			sent = message
+			return true
+		},
+	}
+	frame := bytecoder.MarshalV1(9, "GET", "/", nil, map[string]string{"X-Trace": "t1", "Cookie": "c"}, responseHeader)
+	frame.Gzip()
+	frame.EncodeWS()
+	if err := msg.FromPb(frame); err != nil {
+		t.Fatal(err)
+	}
+	msg.SendResponse(200, nil, map[string]string{"X-Handler": "h"})
+
+	coder := bytecoder.StreamCoder(sent)
+	coder.DecodeWS()
+	coder.UnGzip()
+	resp, err := coder.UnmarshalV0()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.GetHeader()
+}
+
+func TestResponseHeader(t *testing.T) {
+	hd := response(t, nil, false)
+	if len(hd) != 1 || hd["X-Handler"] != "h" {
+		t.Fatalf("unexpected header %v", hd)
+	}
+
+	hd = response(t, &wsmessage.HeaderPolicy{NodeId: "node-1", Deny: []string{"authorization", "cookie", "secret-token"}}, true)
+	for k, v := range map[string]string{
+		"X-Handler":                 "h",
+		"X-Trace":                   "t1",
+		"User-Id":                   "u1",
+		wsmessage.WsGroupHeader:     "vip",
+		wsmessage.WsRequestIdHeader: "9",
+		wsmessage.WsNodeHeader:      "node-1",
+	} {
+		if hd[k] != v {
+			t.Fatalf("header %s: want %s got %v", k, v, hd)
+		}
+	}
+	for _, k := range []string{"Authorization", "Secret-Token", "Cookie", wsmessage.WsIdentityHeader} {
+		if _, ok := hd[k]; ok {
+			t.Fatalf("header %s leaked: %v", k, hd)
+		}
+	}
+	if hd[wsmessage.WsDurationHeader] == "" {
+		t.Fatalf("missing duration header %v", hd)
+	}
+
+	// 自定义的黑名单不会替换默认的
+	hd = response(t, &wsmessage.HeaderPolicy{Deny: []string{"secret-token"}}, true)
+	for _, k := range []string{"Authorization", "Secret-Token", "Cookie"} {
+		if _, ok := hd[k]; ok {
+			t.Fatalf("header %s leaked: %v", k, hd)
+		}
+	}
+	hd = response(t, &wsmessage.HeaderPolicy{Expose: []string{"cookie"}}, true)
+	if hd["Cookie"] != "c" || hd["Authorization"] != "" {
+		t.Fatalf("unexpected header %v", hd)
+	}
+
+	hd = response(t, &wsmessage.HeaderPolicy{Allow: []string{"User-Id"}}, true)
+	if hd["User-Id"] != "u1" || hd[wsmessage.WsGroupHeader] != "" || hd["Secret-Token"] != "" {
+		t.Fatalf("unexpected header %v", hd)
+	}
+}
+
+// 请求自带的头不能伪造内部的头，也不能覆盖链接上鉴权写入的头
+func TestGetAllHeader(t *testing.T) {
+	msg := &wsmessage.WSMessage{
+		OrgHeader: http.Header{
+			"Cert-Subject":             []string{"CN=alice"},
+			wsmessage.WsIdentityHeader: []string{"alice"},
+		},
+		Header: map[string]string{
+			"mx-wsgo-identity": "admin",
+			"Mx-Wsgo-Group":    "vip",
+			"cert-subject":     "CN=admin",
+			"x-trace":          "t1",
+		},
+	}
+	hd := msg.GetAllHeader()
+	if v := hd.Values("Cert-Subject"); len(v) != 1 || v[0] != "CN=alice" {
+		t.Fatalf("auth header overridden %v", v)
+	}
+	if v := hd.Values(wsmessage.WsIdentityHeader); len(v) != 0 {
+		t.Fatalf("internal header leaked %v", v)
+	}
+	if v := hd.Get(wsmessage.WsGroupHeader); v != "" {
+		t.Fatalf("internal header forged %s", v)
+	}
+	if v := hd.Get("X-Trace"); v != "t1" {
+		t.Fatalf("request header lost %s", v)
+	}
+	if len(hd) != 2 {
+		t.Fatalf("unexpected header %v", hd)
+	}
+}
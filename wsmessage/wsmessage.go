@@ -1,12 +1,15 @@
 package wsmessage
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/hnchenkai/mx-wsgo/bytecoder"
+	"github.com/hnchenkai/mx-wsgo/tracing"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -17,6 +20,13 @@ const (
 	PrefixLocalHeader = "Mx-Wsgo-"
 	WsGroupHeader     = PrefixLocalHeader + "Group"
 	WsStatusHeader    = PrefixLocalHeader + "Status"
+	WsIdentityHeader  = PrefixLocalHeader + "Identity"
+	// 断线重连时携带会话token的头
+	WsSessionHeader = PrefixLocalHeader + "Session"
+	// 排队优先级，由鉴权器写入，客户端不能直接设置
+	WsPriorityHeader = PrefixLocalHeader + "Priority"
+	// 断线重连时携带已经收到的最后一条消息序号的头
+	WsSessionSeqHeader = PrefixLocalHeader + "Session-Seq"
 )
 
 const (
@@ -28,6 +38,7 @@ const (
 
 	CmdWait   Cmd = "wait"   // 开启排队模式后被列入排队状态的
 	CmdReject Cmd = "reject" // 开启排队模式后被拒绝的
+	CmdResume Cmd = "resume" // 断线重连后恢复了会话
 )
 
 // 链接状态信息
@@ -62,11 +73,20 @@ type WSMessage struct {
 	ReqId   int64                 `json:"requestId"`
 	Method  string                `json:"method"`
 	Route   string                `json:"route"`
-	Cmd     bytecoder.MsgLocalCmd `json:"cmd"` // cmd版本才会有
+	Cmd     bytecoder.MsgLocalCmd `json:"cmd"` // cmd版本和v2版本才会有
 	Message []byte                `json:"message"`
 	Header  map[string]string     `json:"header"`
 
 	ResponseHeader bool `json:"responseHeader"`
+	// 应答头的生成规则，为空使用默认规则
+	HeaderPolicy *HeaderPolicy `json:"-"`
+	// 收到消息的时间
+	ReceivedAt time.Time `json:"-"`
+	// 追踪的钩子，为空不追踪
+	Tracer tracing.Tracer `json:"-"`
+
+	// 解码之后带有追踪信息
+	ctx context.Context
 }
 
 // 从json格式过来的
@@ -76,13 +96,21 @@ func (app *WSMessage) FromJson(jsonbt []byte) error {
 
 // 从数据流过来的
 func (app *WSMessage) FromPb(msg1 []byte) error {
+	if app.ReceivedAt.IsZero() {
+		app.ReceivedAt = time.Now()
+	}
 	coder := bytecoder.StreamCoder(msg1)
 	coder.DecodeWS()
 	// 这里要压缩获取信息
-	coder.UnGzip()
+	if err := coder.UnGzip(); err != nil {
+		return fmt.Errorf("ungzip message: %w", err)
+	}
 	switch coder.Version() {
 	case bytecoder.Version_VERSION_1:
-		msg, _ := coder.UnmarshalV1()
+		msg, err := coder.UnmarshalV1()
+		if err != nil {
+			return fmt.Errorf("unmarshal message: %w", err)
+		}
 		app.Version = int(bytecoder.Version_VERSION_1)
 		app.ReqId = msg.GetRequestId()
 		app.Route = msg.GetRoute()
@@ -91,10 +119,14 @@ func (app *WSMessage) FromPb(msg1 []byte) error {
 		app.Method = msg.GetMethod()
 		app.ResponseHeader = msg.GetResponseHeader()
 	case bytecoder.Version_VERSION_2:
-		msg, _ := coder.UnmarshalV2()
+		msg, err := coder.UnmarshalV2()
+		if err != nil {
+			return fmt.Errorf("unmarshal message: %w", err)
+		}
 		app.Version = int(bytecoder.Version_VERSION_2)
 		app.ReqId = msg.GetRequestId()
-		app.Route = fmt.Sprintf("/%d", app.Cmd)
+		app.Cmd = bytecoder.MsgLocalCmd(msg.GetCmd())
+		app.Route = fmt.Sprintf("/%d", msg.GetCmd())
 		app.Message = msg.GetBody()
 		app.Method = "POST"
 		app.Header = msg.GetHeader()
@@ -103,7 +135,10 @@ func (app *WSMessage) FromPb(msg1 []byte) error {
 		// 收到一个错误信息，我也是真xxx了
 		return fmt.Errorf("error message")
 	case bytecoder.Version_VERSION_CMD:
-		msg, _ := coder.UnmarshalCmd()
+		msg, err := coder.UnmarshalCmd()
+		if err != nil {
+			return fmt.Errorf("unmarshal message: %w", err)
+		}
 		app.Version = int(bytecoder.Version_VERSION_CMD)
 		app.ReqId = msg.GetRequestId()
 		app.Cmd = msg.GetCmd()
@@ -114,19 +149,50 @@ func (app *WSMessage) FromPb(msg1 []byte) error {
 		return fmt.Errorf("unknown version %d", coder.Version())
 	}
 
+	// 延续客户端带过来的 traceparent
+	app.ctx = tracing.ContextWithSpan(app.Context(), tracing.FromHeader(app.Header))
 	return nil
 }
 
+// Context 消息的context，解码之后带有追踪信息
+func (app *WSMessage) Context() context.Context {
+	if app.ctx == nil {
+		return context.Background()
+	}
+	return app.ctx
+}
+
+// SetContext 替换消息的context
+func (app *WSMessage) SetContext(ctx context.Context) {
+	app.ctx = ctx
+}
+
+// 调用追踪的钩子
+func (app *WSMessage) Trace(ev tracing.Event) {
+	ev.ClientId = app.ClientId
+	ev.Group = app.Group()
+	ev.ReqId = app.ReqId
+	ev.Route = app.Route
+	tracing.Emit(app.Tracer, app.Context(), ev)
+}
+
 func (app *WSMessage) Group() string {
 	return app.OrgHeader.Get(WsGroupHeader)
 }
 
+// 鉴权通过后的身份标识
+func (app *WSMessage) Identity() string {
+	return app.OrgHeader.Get(WsIdentityHeader)
+}
+
 // 应答消息给用户
+// 客户端设置了 response_header 的时候会带上请求头、链接头和服务端生成的头
 func (app *WSMessage) SendResponse(code int32, body []byte, header map[string]string) bool {
-	coder := bytecoder.MarshalV0(app.ReqId, code, body, header)
+	coder := bytecoder.MarshalV0(app.ReqId, code, body, app.responseHeader(header))
 	// dst, _ := app.gzip(coder)
 	coder.Gzip()
 	coder.EncodeWS()
+	app.Trace(tracing.Event{Stage: tracing.StageResponse, Code: code})
 	return app.Send(coder)
 }
 
@@ -134,10 +200,11 @@ func (app *WSMessage) SendError(code int32, body string, header map[string]strin
 	bt, _ := json.Marshal(map[string]string{
 		"message": body,
 	})
-	coder := bytecoder.MarshalV0(app.ReqId, code, bt, header)
+	coder := bytecoder.MarshalV0(app.ReqId, code, bt, app.responseHeader(header))
 	// dst, _ := app.gzip(coder)
 	coder.Gzip()
 	coder.EncodeWS()
+	app.Trace(tracing.Event{Stage: tracing.StageResponse, Code: code})
 	return app.Send(coder)
 }
 
@@ -183,26 +250,45 @@ func (app *WSMessage) SetAcceptMode() {
 }
 
 func (app *WSMessage) SetWaitMode(self int64, total int64) {
-	info := bytecoder.MessageWaitInfo{
-		Self:  self,
-		Total: total,
-	}
+	// 没有速度信息，-1 表示无法估算等待时间
+	app.SetWaitModeInfo(&bytecoder.MessageWaitInfo{
+		Self:       self,
+		Total:      total,
+		EtaSeconds: -1,
+	})
+}
 
-	bt, _ := proto.Marshal(&info)
+// 进入排队状态，带上预计的等待时间等信息
+func (app *WSMessage) SetWaitModeInfo(info *bytecoder.MessageWaitInfo) {
 	app.AddHeader(WsStatusHeader, "wait")
-	app.SendResponseCmd(bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_WAIT, bt, nil)
+	app.SendWaitInfo(info)
+}
+
+// 推送最新的排队信息，不修改链接状态
+func (app *WSMessage) SendWaitInfo(info *bytecoder.MessageWaitInfo) bool {
+	bt, _ := proto.Marshal(info)
+	return app.SendResponseCmd(bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_WAIT, bt, nil)
 }
 
 // 获取额外注入的消息头
+// 请求自带的头不能设置内部的 Mx-Wsgo- 头，也不能覆盖链接上的头，避免伪造身份和鉴权的属性
 func (app *WSMessage) GetAllHeader() http.Header {
 	hd := http.Header{}
 	for k, v := range app.OrgHeader {
-		if !strings.Contains(k, PrefixLocalHeader) {
-			hd[k] = v
+		k = http.CanonicalHeaderKey(k)
+		if !strings.HasPrefix(k, PrefixLocalHeader) {
+			hd[k] = append(hd[k], v...)
 		}
 	}
 
 	for k, v := range app.Header {
+		k = http.CanonicalHeaderKey(k)
+		if strings.HasPrefix(k, PrefixLocalHeader) {
+			continue
+		}
+		if _, ok := hd[k]; ok {
+			continue
+		}
 		hd[k] = []string{v}
 	}
 
@@ -210,12 +296,17 @@ func (app *WSMessage) GetAllHeader() http.Header {
 }
 
 func (app *WSMessage) WaitResponse(self int64, total int64) {
-	info := bytecoder.MessageWaitInfo{
-		Self:  self,
-		Total: total,
-	}
+	// 没有速度信息，-1 表示无法估算等待时间
+	app.WaitResponseInfo(&bytecoder.MessageWaitInfo{
+		Self:       self,
+		Total:      total,
+		EtaSeconds: -1,
+	})
+}
 
-	bt, _ := proto.Marshal(&info)
+// 应答主动查询的排队信息
+func (app *WSMessage) WaitResponseInfo(info *bytecoder.MessageWaitInfo) {
+	bt, _ := proto.Marshal(info)
 	app.SendResponseCmd(bytecoder.MsgLocalCmd_MSG_LOCAL_CMD_WS_RESP, bt, nil)
 }
 
@@ -0,0 +1,43 @@
+package wsmessage_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+	"google.golang.org/protobuf/proto"
+)
+
+// 只有位置的旧接口，不能告诉客户端马上就能接入
+func TestWaitInfoUnknownEta(t *testing.T) {
+	var sent []byte
+	msg := &wsmessage.WSMessage{
+		OrgHeader: http.Header{},
+		AddHeader: func(key, value string) bool { return true },
+		Send: func(message []byte) bool {
+			sent = message
+			return true
+		},
+	}
+	for name, send := range map[string]func(){
+		"SetWaitMode":  func() { msg.SetWaitMode(3, 10) },
+		"WaitResponse": func() { msg.WaitResponse(3, 10) },
+	} {
+		send()
+		coder := bytecoder.StreamCoder(sent)
+		coder.DecodeWS()
+		coder.UnGzip()
+		cmd, err := coder.UnmarshalCmd()
+		if err != nil {
+			t.Fatal(err)
+		}
+		info := &bytecoder.MessageWaitInfo{}
+		if err := proto.Unmarshal(cmd.GetBody(), info); err != nil {
+			t.Fatal(err)
+		}
+		if info.GetSelf() != 3 || info.GetTotal() != 10 || info.GetEtaSeconds() != -1 {
+			t.Fatalf("%s: unexpected wait info %+v", name, info)
+		}
+	}
+}
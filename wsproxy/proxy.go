@@ -0,0 +1,212 @@
+package wsproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hnchenkai/mx-wsgo/httpbridge"
+	"github.com/hnchenkai/mx-wsgo/router"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+)
+
+// 默认的转发超时
+const DefaultTimeout = 30 * time.Second
+
+// 不转发的逐跳头
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Transfer-Encoding",
+	"Te",
+	"Trailer",
+	"Upgrade",
+	"Accept-Encoding",
+}
+
+// 上游服务
+type Upstream struct {
+	// 匹配的路由前缀，为空匹配所有路由
+	Prefix string
+	// 匹配的分组(WsGroupHeader)，为空匹配所有分组
+	Group string
+	// 上游地址，例如 http://order-service:8080/api
+	Target string
+	// 转发的时候去掉匹配的前缀
+	StripPrefix bool
+	// 这个上游的超时，为0使用 Proxy.Timeout
+	Timeout time.Duration
+
+	target *url.URL
+}
+
+// 把 Messagev1 转发给上游的http服务
+type Proxy struct {
+	upstreams []*Upstream
+	// 为空使用 http.DefaultClient
+	Client *http.Client
+	// 默认超时
+	Timeout time.Duration
+	// 鉴权写入链接header的属性，例如 Cert-Subject，只转发链接上的值，链接上没有的时候去掉
+	// 只能填鉴权器每次都会写入的属性(例如 JWTAuthenticator.Claims)，否则客户端可以通过 Mx-Ws- 前缀在链接上伪造
+	AuthHeaders []string
+}
+
+func New(upstreams ...Upstream) (*Proxy, error) {
+	p := &Proxy{
+		Timeout: DefaultTimeout,
+	}
+	for _, v := range upstreams {
+		if err := p.Add(v); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Add 添加一个上游
+func (p *Proxy) Add(upstream Upstream) error {
+	target, err := url.Parse(upstream.Target)
+	if err != nil {
+		return err
+	}
+	if target.Scheme == "" || target.Host == "" {
+		return errors.New("invalid upstream target " + upstream.Target)
+	}
+	upstream.target = target
+	p.upstreams = append(p.upstreams, &upstream)
+	return nil
+}
+
+// 选择上游，分组匹配的优先，然后前缀最长的优先
+func (p *Proxy) match(group string, route string) *Upstream {
+	var best *Upstream
+	for _, v := range p.upstreams {
+		if v.Group != "" && v.Group != group {
+			continue
+		}
+		if !strings.HasPrefix(route, v.Prefix) {
+			continue
+		}
+		if best == nil ||
+			(v.Group != "" && best.Group == "") ||
+			((v.Group != "") == (best.Group != "") && len(v.Prefix) > len(best.Prefix)) {
+			best = v
+		}
+	}
+	return best
+}
+
+// Handle 可以作为路由的处理函数使用
+func (p *Proxy) Handle(ctx context.Context, msg *wsmessage.WSMessage) (int32, []byte, map[string]string, error) {
+	upstream := p.match(msg.Group(), msg.Route)
+	if upstream == nil {
+		return 0, nil, nil, router.NewError(http.StatusNotFound, "no upstream")
+	}
+
+	timeout := upstream.Timeout
+	if timeout == 0 {
+		timeout = p.Timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	req, err := p.newRequest(ctx, upstream, msg)
+	if err != nil {
+		return 0, nil, nil, router.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, nil, nil, ctx.Err()
+		}
+		return 0, nil, nil, router.NewError(http.StatusBadGateway, err.Error())
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, nil, router.NewError(http.StatusBadGateway, err.Error())
+	}
+
+	var header map[string]string
+	if msg.ResponseHeader {
+		for _, k := range hopHeaders {
+			resp.Header.Del(k)
+		}
+		header = httpbridge.FlatHeader(resp.Header)
+	}
+	return int32(resp.StatusCode), body, header, nil
+}
+
+// 生成转发给上游的请求
+func (p *Proxy) newRequest(ctx context.Context, upstream *Upstream, msg *wsmessage.WSMessage) (*http.Request, error) {
+	in, err := httpbridge.NewRequest(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	path := in.URL.Path
+	if upstream.StripPrefix {
+		path = "/" + strings.TrimPrefix(strings.TrimPrefix(path, upstream.Prefix), "/")
+	}
+	out := *upstream.target
+	out.Path = strings.TrimSuffix(out.Path, "/") + path
+	out.RawQuery = in.URL.RawQuery
+
+	req, err := http.NewRequestWithContext(ctx, in.Method, out.String(), in.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(msg.Message))
+	// 链接上转发过来的头和请求自带的头，请求自带的头不能覆盖链接上的头
+	req.Header = in.Header
+	for _, k := range hopHeaders {
+		req.Header.Del(k)
+	}
+	// 内部的头只使用链接上可信的值，客户端伪造的全部去掉
+	for k := range req.Header {
+		if strings.HasPrefix(http.CanonicalHeaderKey(k), wsmessage.PrefixLocalHeader) {
+			delete(req.Header, k)
+		}
+	}
+	for _, k := range []string{wsmessage.WsGroupHeader, wsmessage.WsIdentityHeader} {
+		if v := msg.OrgHeader.Get(k); v != "" {
+			req.Header.Set(k, v)
+		}
+	}
+	for _, k := range p.AuthHeaders {
+		if v := msg.OrgHeader.Values(k); len(v) > 0 {
+			req.Header[http.CanonicalHeaderKey(k)] = v
+		} else {
+			req.Header.Del(k)
+		}
+	}
+	req.Header.Set(wsmessage.PrefixLocalHeader+"Client-Id", msg.ClientId)
+	if msg.Host != "" {
+		req.Header.Set("X-Forwarded-Host", msg.Host)
+	}
+	return req, nil
+}
+
+// Dispatch 可以直接作为分发器使用，其他事件忽略
+func (p *Proxy) Dispatch(cmd wsmessage.Cmd, msg *wsmessage.WSMessage) {
+	if cmd != wsmessage.CmdMessage {
+		return
+	}
+	code, body, header, err := p.Handle(msg.Context(), msg)
+	router.Respond(msg, code, body, header, err)
+}
@@ -0,0 +1,222 @@
+package wsproxy_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hnchenkai/mx-wsgo/auth"
+	"github.com/hnchenkai/mx-wsgo/bytecoder"
+	"github.com/hnchenkai/mx-wsgo/serverunit"
+	"github.com/hnchenkai/mx-wsgo/wsmessage"
+	"github.com/hnchenkai/mx-wsgo/wsproxy"
+)
+
+func request(t *testing.T, proxy *wsproxy.Proxy, group string, method string, route string, body string) *bytecoder.Messagev0 {
+	var sent []byte
+	header := http.Header{"User-Id": []string{"u1"}}
+	if group != "" {
+		header.Set(wsmessage.WsGroupHeader, group)
+	}
+	msg := &wsmessage.WSMessage{
+		ClientId:  "c1",
+		OrgHeader: header,
+		Send: func(message []byte) bool {
+			sent = message
+			return true
+		},
+	}
+	frame := bytecoder.MarshalV1(1, method, route, []byte(body), nil, true)
+	frame.Gzip()
+	frame.EncodeWS()
+	if err := msg.FromPb(frame); err != nil {
+		t.Fatal(err)
+	}
+	proxy.Dispatch(wsmessage.CmdMessage, msg)
+
+	coder := bytecoder.StreamCoder(sent)
+	coder.DecodeWS()
+	coder.UnGzip()
+	resp, err := coder.UnmarshalV0()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestProxy(t *testing.T) {
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				time.Sleep(200 * time.Millisecond)
+			}
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("X-Upstream", name)
+			w.Header().Set("X-User", r.Header.Get("User-Id"))
+			w.Header().Set("X-Group", r.Header.Get(wsmessage.WsGroupHeader))
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(r.Method + " " + r.URL.RequestURI() + " " + string(body)))
+		}))
+	}
+	orders := newUpstream("orders")
+	defer orders.Close()
+	vip := newUpstream("vip")
+	defer vip.Close()
+
+	proxy, err := wsproxy.New(
+		wsproxy.Upstream{Prefix: "/orders", Target: orders.URL + "/api", StripPrefix: true},
+		wsproxy.Upstream{Prefix: "/orders", Group: "vip", Target: vip.URL},
+		wsproxy.Upstream{Prefix: "/slow", Target: orders.URL, Timeout: 50 * time.Millisecond},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := request(t, proxy, "", "POST", "/orders/1?x=2", "hi")
+	if resp.GetCode() != http.StatusAccepted || string(resp.GetMessage()) != "POST /api/1?x=2 hi" {
+		t.Fatalf("unexpected response %v", resp)
+	}
+	if resp.GetHeader()["X-Upstream"] != "orders" || resp.GetHeader()["X-User"] != "u1" {
+		t.Fatalf("unexpected header %v", resp.GetHeader())
+	}
+
+	resp = request(t, proxy, "vip", "GET", "/orders/1", "")
+	if resp.GetHeader()["X-Upstream"] != "vip" || resp.GetHeader()["X-Group"] != "vip" {
+		t.Fatalf("unexpected header %v", resp.GetHeader())
+	}
+
+	resp = request(t, proxy, "", "GET", "/slow", "")
+	if resp.GetCode() != http.StatusGatewayTimeout {
+		t.Fatalf("unexpected code %d", resp.GetCode())
+	}
+
+	resp = request(t, proxy, "", "GET", "/unknown", "")
+	if resp.GetCode() != http.StatusNotFound {
+		t.Fatalf("unexpected code %d", resp.GetCode())
+	}
+}
+
+// 客户端在消息里面伪造身份和鉴权的属性，上游只能收到链接上的值
+func TestProxySpoof(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Identity", strings.Join(r.Header.Values(wsmessage.WsIdentityHeader), ","))
+		w.Header().Set("X-Subject", strings.Join(r.Header.Values("Cert-Subject"), ","))
+		w.Header().Set("X-Trace", r.Header.Get("X-Trace"))
+	}))
+	defer upstream.Close()
+	proxy, err := wsproxy.New(wsproxy.Upstream{Target: upstream.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.AuthHeaders = []string{"Cert-Subject"}
+
+	forged := map[string]string{
+		"mx-wsgo-identity": "admin",
+		"cert-subject":     "CN=admin",
+		"x-trace":          "t1",
+	}
+	for _, tc := range []struct {
+		header   http.Header
+		identity string
+		subject  string
+	}{
+		{http.Header{wsmessage.WsIdentityHeader: []string{"alice"}, "Cert-Subject": []string{"CN=alice"}}, "alice", "CN=alice"},
+		// 没有鉴权的链接
+		{http.Header{}, "", ""},
+	} {
+		var sent []byte
+		msg := &wsmessage.WSMessage{
+			ClientId:  "c1",
+			OrgHeader: tc.header,
+			Send: func(message []byte) bool {
+				sent = message
+				return true
+			},
+		}
+		frame := bytecoder.MarshalV1(1, "GET", "/", nil, forged, true)
+		frame.Gzip()
+		frame.EncodeWS()
+		if err := msg.FromPb(frame); err != nil {
+			t.Fatal(err)
+		}
+		proxy.Dispatch(wsmessage.CmdMessage, msg)
+
+		coder := bytecoder.StreamCoder(sent)
+		coder.DecodeWS()
+		coder.UnGzip()
+		resp, err := coder.UnmarshalV0()
+		if err != nil {
+			t.Fatal(err)
+		}
+		header := resp.GetHeader()
+		if header["X-Identity"] != tc.identity || header["X-Subject"] != tc.subject || header["X-Trace"] != "t1" {
+			t.Fatalf("unexpected upstream header %v", header)
+		}
+	}
+}
+
+// 客户端通过链接上的 Mx-Ws- 前缀伪造token里面没有的claim，上游不能收到
+func TestProxySpoofClaim(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Role", strings.Join(r.Header.Values("Role"), ","))
+	}))
+	defer upstream.Close()
+	proxy, err := wsproxy.New(wsproxy.Upstream{Target: upstream.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy.AuthHeaders = []string{"Role"}
+
+	secret := []byte("test-secret")
+	authenticator, err := auth.NewJWTAuthenticator(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator.Claims = []string{"Role"}
+	unit := serverunit.NewServerUnit(proxy.Dispatch, nil, serverunit.WithAuthenticator(authenticator))
+	go unit.Run()
+	defer unit.Close()
+	svr := httptest.NewServer(unit)
+	defer svr.Close()
+
+	token, err := auth.SignJWT(secret, "HS256", map[string]interface{}{"sub": "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{}
+	header.Set(wsmessage.PrefixProxyHeader+"Role", "admin")
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(svr.URL, "http")+"?token="+token, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	frame := bytecoder.MarshalV1(5, "GET", "/", nil, nil, true)
+	frame.Gzip()
+	frame.EncodeWS()
+	if err := conn.WriteMessage(websocket.BinaryMessage, frame.Byte()); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		coder := bytecoder.StreamCoder(data)
+		coder.DecodeWS()
+		coder.UnGzip()
+		resp, err := coder.UnmarshalV0()
+		if err != nil || resp.GetRequestId() != 5 {
+			continue
+		}
+		if resp.GetCode() != http.StatusOK || resp.GetHeader()["X-Role"] != "" {
+			t.Fatalf("unexpected response %d %v", resp.GetCode(), resp.GetHeader())
+		}
+		return
+	}
+}